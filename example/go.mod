@@ -6,6 +6,7 @@ replace github.com/kenisad5566/redissub => ./../
 
 require (
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/json-iterator/go v1.1.12
 	github.com/kenisad5566/redissub v0.0.0-20230111023700-6b3f4e4b4a55
 	github.com/zeromicro/go-zero v1.4.3
 )
@@ -25,7 +26,6 @@ require (
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/grpc-ecosystem/grpc-gateway/v2 v2.7.0 // indirect
-	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/mattn/go-colorable v0.1.9 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.2-0.20181231171920-c182affec369 // indirect
@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/kenisad5566/redissub/redissub"
 	"github.com/zeromicro/go-zero/core/logx"
 	"github.com/zeromicro/go-zero/core/service"
@@ -68,7 +67,7 @@ func main() {
 	redissub.AddWsEvent("joinRoom", func(ctx context.Context, data []byte) string {
 		return channel
 	}, func(client *redissub.Client, data []byte) {
-		client.Send <- data
+		client.Deliver(data)
 	})
 
 	engine.AddRoute(rest.Route{
@@ -98,8 +97,12 @@ func main() {
 				Data:      "1",
 				Time:      time.Now().UnixMilli(),
 			}
-			data2, _ := jsoniter.Marshal(event2)
-			PubSubClient.Publish(context.Background(), channel, data2)
+			id, err := PubSubClient.Publish(context.Background(), channel, event2)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintln(w, id)
 		},
 	})
 
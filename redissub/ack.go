@@ -0,0 +1,205 @@
+package redissub
+
+import (
+	"context"
+	"errors"
+	red "github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"strings"
+	"time"
+)
+
+const (
+	// NackRetry resends the message now instead of waiting for its backoff
+	NackRetry = "retry"
+	// NackReject moves the message to the dead letter stream, it is not resent
+	NackReject = "reject"
+)
+
+var (
+	ErrNotWaiting         = errors.New("message not waiting for ack")
+	ErrAckChannelRequired = errors.New("cumulative ack requires a channel")
+)
+
+// ackScript acknowledges waiter messages of one channel and client.
+// Acked messages move from the waiter to the receiver, their attempts are dropped and the offset
+// becomes the highest acked sequence, never going back.
+// KEYS[1] waiter, KEYS[2] receiver, KEYS[3] offset, KEYS[4] attempts
+// ARGV[1] key ttl in milliseconds, ARGV[2] sequence, ARGV[3] 1 acks every waiter message up to the sequence,
+// ARGV[4..] message ids
+// Returns the number of waiter messages acked
+var ackScript = red.NewScript(`
+local ttl = tonumber(ARGV[1])
+local seq = tonumber(ARGV[2])
+local ids = {}
+for i = 4, #ARGV do
+	ids[#ids + 1] = ARGV[i]
+end
+if ARGV[3] == '1' then
+	local all = redis.call('HGETALL', KEYS[1])
+	for i = 1, #all, 2 do
+		local ok, event = pcall(cjson.decode, all[i + 1])
+		if ok and type(event) == 'table' and type(event.Seq) == 'number' and event.Seq <= seq then
+			ids[#ids + 1] = all[i]
+		end
+	end
+end
+local acked = 0
+for _, id in ipairs(ids) do
+	local frame = redis.call('HGET', KEYS[1], id)
+	if frame then
+		acked = acked + 1
+		local ok, event = pcall(cjson.decode, frame)
+		if ok and type(event) == 'table' and type(event.Seq) == 'number' and event.Seq > seq then
+			seq = event.Seq
+		end
+		redis.call('HDEL', KEYS[1], id)
+	else
+		frame = cjson.encode({Id = id})
+	end
+	redis.call('HSET', KEYS[2], id, frame)
+	redis.call('HDEL', KEYS[4], id)
+end
+if #ids > 0 and ttl > 0 then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+if seq > 0 then
+	local current = tonumber(redis.call('GET', KEYS[3]) or '0') or 0
+	if seq > current then
+		if ttl > 0 then
+			redis.call('SET', KEYS[3], string.format('%d', seq), 'PX', ttl)
+		else
+			redis.call('SET', KEYS[3], string.format('%d', seq))
+		end
+	end
+end
+return acked
+`)
+
+// AckRequest is the Data of an ack event. An Event acks itself, Ids acks many messages at once and
+// Seq without Id or Ids acks every message up to Seq of Channel, sequences are per channel so it needs one.
+// Without Channel the ids are acked on every subscribed channel and Seq is ignored, each offset only moves to
+// the sequences of the acked messages found on its channel.
+type AckRequest struct {
+	Id      string   `json:"Id,omitempty"`
+	Channel string   `json:"Channel,omitempty"`
+	Ids     []string `json:"Ids,omitempty"`
+	Seq     int64    `json:"Seq,omitempty"`
+}
+
+// AckBatch acknowledges the messages of request in one pipelined round trip and returns how many
+// waiter messages were acked.
+func (s *Solid) AckBatch(ctx context.Context, request *AckRequest) (int64, error) {
+	ids := request.Ids
+	if request.Id != "" {
+		ids = append([]string{request.Id}, ids...)
+	}
+	cumulative := len(ids) == 0
+	if cumulative && request.Seq <= 0 {
+		return 0, nil
+	}
+	if cumulative && request.Channel == "" {
+		return 0, ErrAckChannelRequired
+	}
+	channels := s.Client.channels()
+	seq := request.Seq
+	if request.Channel != "" {
+		if !s.Client.subscribed(request.Channel) {
+			return 0, ErrNotSubscribed
+		}
+		channels = []string{request.Channel}
+	} else {
+		seq = 0 // sequences are per channel, offsets only move by the acked frames found on each one
+	}
+
+	run := func() ([]*red.Cmd, error) {
+		pipe := s.Rdb.Pipeline()
+		cmds := make([]*red.Cmd, 0, len(channels))
+		for _, channel := range channels {
+			online := s.online(channel)
+			keys := []string{online.Waiter.Key, online.Receiver.Key, online.Offset.Key, online.Attempts.Key}
+			args := make([]interface{}, 0, len(ids)+3)
+			args = append(args, s.ExpireTime.Milliseconds(), seq, boolArg(cumulative))
+			for _, id := range ids {
+				args = append(args, id)
+			}
+			cmds = append(cmds, ackScript.EvalSha(ctx, pipe, keys, args...))
+		}
+		_, err := pipe.Exec(ctx)
+		return cmds, err
+	}
+
+	cmds, err := run()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		if err = ackScript.Load(ctx, s.Rdb).Err(); err != nil {
+			return 0, err
+		}
+		cmds, err = run()
+	}
+	if err != nil {
+		return 0, err
+	}
+	var acked int64
+	for _, cmd := range cmds {
+		n, _ := cmd.Int64()
+		acked += n
+	}
+	return acked, nil
+}
+
+// NackRequest is the Data of a nack event, Mode is NackRetry or NackReject, default NackRetry.
+// Without Channel the message is looked up on every subscribed channel.
+type NackRequest struct {
+	Id      string `json:"Id"`
+	Channel string `json:"Channel,omitempty"`
+	Mode    string `json:"Mode,omitempty"`
+}
+
+// Nack retries or rejects a message waiting for ack. A retry counts as an attempt,
+// so a message nacked MaxAttempts times is dead lettered like one never acked.
+func (s *Solid) Nack(ctx context.Context, request *NackRequest) error {
+	channels := s.Client.channels()
+	if request.Channel != "" {
+		if !s.Client.subscribed(request.Channel) {
+			return ErrNotSubscribed
+		}
+		channels = []string{request.Channel}
+	}
+	for _, channel := range channels {
+		online := s.online(channel)
+		frame := online.Waiter.Get(ctx, request.Id)
+		if frame == nil {
+			continue
+		}
+		var event Event
+		if err := jsoniter.Unmarshal(frame, &event); err != nil {
+			return err
+		}
+		attempt, ok := online.Attempts.Get(ctx, []string{event.Id})[event.Id]
+		if !ok {
+			attempt = &Attempt{Count: 1}
+		}
+
+		if request.Mode == NackReject {
+			return online.deadLetter(ctx, &event, frame, DeadLetterRejected, attempt.Count)
+		}
+		if s.outOfAttempts(attempt) {
+			return s.giveUp(ctx, online, channel, &event, frame, attempt.Count)
+		}
+		if err := s.Client.Deliver(frame); err != nil {
+			return err // still in waiter, resent after its backoff
+		}
+		attempt.Count++
+		attempt.NextAt = time.Now().Add(s.backoff(attempt.Count)).UnixMilli()
+		online.Attempts.Set(ctx, event.Id, attempt)
+		return nil
+	}
+	return ErrNotWaiting
+}
+
+func boolArg(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,280 @@
+package redissub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// seqBase is around the microsecond clock sequence counters are seeded from
+const seqBase int64 = 1_700_000_000_000_000
+
+func pushWaiting(t *testing.T, c *Client, channel string, events ...*Event) {
+	t.Helper()
+	online := c.Solid.online(channel)
+	for _, event := range events {
+		frame, err := jsoniter.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		online.Waiter.Push(context.Background(), frame)
+	}
+}
+
+func TestAckBatchIds(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders", "news")
+	pushWaiting(t, c, "orders", &Event{Id: "a", Seq: seqBase + 1}, &Event{Id: "b", Seq: seqBase + 2}, &Event{Id: "c", Seq: seqBase + 3})
+	pushWaiting(t, c, "news", &Event{Id: "n", Seq: 7})
+
+	// ids without Channel are looked up on every subscribed channel
+	acked, err := c.Solid.AckBatch(ctx, &AckRequest{Id: "a", Ids: []string{"c", "n", "unknown"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acked != 3 {
+		t.Fatalf("acked = %d, want 3", acked)
+	}
+	if got := waiterIds(t, rdb, "orders", "u1"); len(got) != 1 || !got["b"] {
+		t.Fatalf("orders waiter = %v, want only b", got)
+	}
+	if got := waiterIds(t, rdb, "news", "u1"); len(got) != 0 {
+		t.Fatalf("news waiter = %v", got)
+	}
+	online := c.Solid.online("orders")
+	if !online.Receiver.IsReceived(ctx, []byte(`{"Id":"a"}`)) || !online.Receiver.IsReceived(ctx, []byte(`{"Id":"c"}`)) {
+		t.Fatal("acked ids not received")
+	}
+	if got := online.Offset.Offset(ctx); got != seqBase+3 {
+		t.Fatalf("offset = %d, want %d", got, seqBase+3)
+	}
+	if ttl := rdb.PTTL(ctx, online.Offset.Key).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("offset ttl = %v", ttl)
+	}
+	if ttl := rdb.PTTL(ctx, online.Receiver.Key).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("receiver ttl = %v", ttl)
+	}
+}
+
+func TestAckBatchWithoutChannelKeepsOtherOffsets(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	// counters of different channels are seeded apart, a is far ahead of b
+	p.Publish(ctx, "a", &Event{Id: "a1"})
+	p.Publish(ctx, "b", &Event{Id: "b1"})
+	rdb.Set(ctx, GenSeqKey("a"), seqBase*2, 0)
+	a2 := &Event{Id: "a2"}
+	p.Publish(ctx, "a", a2)
+	b2 := &Event{Id: "b2"}
+	p.Publish(ctx, "b", b2)
+
+	c := newTestSubscriber(p, "u1", "a", "b")
+	c.Solid.online("b").Receiver.Received(ctx, &Event{Id: "b1"})
+	frame, _ := jsoniter.Marshal(a2)
+	c.Solid.online("a").Waiter.Push(ctx, frame)
+
+	// Solid.Ack of an event without Channel
+	c.Solid.Ack(ctx, &Event{Id: "a2", Seq: a2.Seq})
+
+	if got := c.Solid.online("a").Offset.Offset(ctx); got != a2.Seq {
+		t.Fatalf("a offset = %d, want %d", got, a2.Seq)
+	}
+	if got := c.Solid.online("b").Offset.Offset(ctx); got != 0 {
+		t.Fatalf("b offset = %d, moved by a sequence of a", got)
+	}
+	c.Solid.PullOfflineMessage()
+	if got := waiterIds(t, rdb, "b", "u1"); !got["b2"] {
+		t.Fatalf("b waiter = %v, unread b2 skipped", got)
+	}
+}
+
+func TestAckBatchCumulative(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	pushWaiting(t, c, "orders", &Event{Id: "a", Seq: seqBase + 1}, &Event{Id: "b", Seq: seqBase + 2}, &Event{Id: "c", Seq: seqBase + 3})
+
+	acked, err := c.Solid.AckBatch(ctx, &AckRequest{Channel: "orders", Seq: seqBase + 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acked != 2 {
+		t.Fatalf("acked = %d, want 2", acked)
+	}
+	if got := waiterIds(t, rdb, "orders", "u1"); len(got) != 1 || !got["c"] {
+		t.Fatalf("waiter = %v, want only c", got)
+	}
+	online := c.Solid.online("orders")
+	if got := online.Offset.Offset(ctx); got != seqBase+2 {
+		t.Fatalf("offset = %d, want %d", got, seqBase+2)
+	}
+
+	// the offset never goes back
+	if _, err := c.Solid.AckBatch(ctx, &AckRequest{Channel: "orders", Seq: seqBase}); err != nil {
+		t.Fatal(err)
+	}
+	if got := online.Offset.Offset(ctx); got != seqBase+2 {
+		t.Fatalf("offset = %d after an older ack, want %d", got, seqBase+2)
+	}
+}
+
+func TestAckBatchCumulativeRequiresChannel(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+
+	c := newTestSubscriber(p, "u1", "orders")
+	pushWaiting(t, c, "orders", &Event{Id: "a", Seq: 1})
+
+	if _, err := c.Solid.AckBatch(context.Background(), &AckRequest{Seq: 1}); err != ErrAckChannelRequired {
+		t.Fatalf("err = %v, want ErrAckChannelRequired", err)
+	}
+	if got := waiterIds(t, rdb, "orders", "u1"); len(got) != 1 {
+		t.Fatalf("waiter = %v, want a kept", got)
+	}
+}
+
+func TestAckBatchNotSubscribed(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	if _, err := c.Solid.AckBatch(ctx, &AckRequest{Id: "a", Channel: "other"}); err != ErrNotSubscribed {
+		t.Fatalf("err = %v, want ErrNotSubscribed", err)
+	}
+	if _, err := c.Solid.AckBatch(ctx, &AckRequest{Channel: "other", Seq: 1}); err != ErrNotSubscribed {
+		t.Fatalf("cumulative err = %v, want ErrNotSubscribed", err)
+	}
+	if n := len(rdb.Keys(ctx, "*other*").Val()); n != 0 {
+		t.Fatalf("%d keys created for an unsubscribed channel", n)
+	}
+}
+
+func TestNackRetry(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders", "news")
+	pushWaiting(t, c, "news", &Event{Id: "a", Channel: "news"})
+
+	// without Channel the message is found on any subscribed channel
+	before := time.Now()
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if frames := drain(c); len(frames) != 1 {
+		t.Fatalf("resent frames = %v", frames)
+	}
+	online := c.Solid.online("news")
+	attempt := online.Attempts.Get(ctx, []string{"a"})["a"]
+	if attempt == nil || attempt.Count != 2 || attempt.NextAt <= before.UnixMilli() {
+		t.Fatalf("attempt = %+v, want the retry counted", attempt)
+	}
+	if got := waiterIds(t, rdb, "news", "u1"); !got["a"] {
+		t.Fatalf("waiter = %v, retried message not waiting for ack", got)
+	}
+}
+
+func TestNackReject(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	pushWaiting(t, c, "orders", &Event{Id: "a", Channel: "orders"})
+	online := c.Solid.online("orders")
+	online.Attempts.Set(ctx, "a", &Attempt{Count: 3})
+
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "a", Channel: "orders", Mode: NackReject}); err != nil {
+		t.Fatal(err)
+	}
+	if frames := drain(c); len(frames) != 0 {
+		t.Fatalf("rejected message resent: %v", frames)
+	}
+	if got := waiterIds(t, rdb, "orders", "u1"); len(got) != 0 {
+		t.Fatalf("waiter = %v after reject", got)
+	}
+	if _, ok := online.Attempts.Get(ctx, []string{"a"})["a"]; ok {
+		t.Fatal("attempts kept after reject")
+	}
+	letters, _ := online.DeadLetters.List(ctx, "", 0)
+	if len(letters) != 1 || letters[0].Reason != DeadLetterRejected || letters[0].Attempts != 3 || letters[0].Event.Id != "a" {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+}
+
+func TestNackOutOfAttempts(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	c.Solid.MaxAttempts = 2
+	gaveUp := 0
+	c.Solid.OnGiveUp = func(client *Client, channel string, event *Event, attempts int) {
+		gaveUp = attempts
+	}
+	pushWaiting(t, c, "orders", &Event{Id: "a", Channel: "orders"})
+
+	// the live delivery and one retry use both attempts
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "a", Channel: "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "a", Channel: "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if frames := drain(c); len(frames) != 1 {
+		t.Fatalf("resent frames = %v, want one retry", frames)
+	}
+	if gaveUp != 2 {
+		t.Fatalf("OnGiveUp attempts = %d, want 2", gaveUp)
+	}
+	letters, _ := c.Solid.online("orders").DeadLetters.List(ctx, "", 0)
+	if len(letters) != 1 || letters[0].Reason != DeadLetterMaxAttempts || letters[0].Attempts != 2 {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "a", Channel: "orders"}); err != ErrNotWaiting {
+		t.Fatalf("nack after give up = %v, want ErrNotWaiting", err)
+	}
+}
+
+func TestNackUnknownId(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "missing"}); err != ErrNotWaiting {
+		t.Fatalf("err = %v, want ErrNotWaiting", err)
+	}
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "missing", Channel: "orders", Mode: NackReject}); err != ErrNotWaiting {
+		t.Fatalf("reject err = %v, want ErrNotWaiting", err)
+	}
+	if letters, _ := c.Solid.online("orders").DeadLetters.List(ctx, "", 0); len(letters) != 0 {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+}
+
+func TestNackNotSubscribed(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "a", Channel: "other"}); err != ErrNotSubscribed {
+		t.Fatalf("err = %v, want ErrNotSubscribed", err)
+	}
+	if n := len(rdb.Keys(ctx, "*other*").Val()); n != 0 {
+		t.Fatalf("%d keys created for an unsubscribed channel", n)
+	}
+}
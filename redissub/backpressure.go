@@ -0,0 +1,95 @@
+package redissub
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// SlowConsumerPolicy decides what Client.Deliver does when the Send buffer is full, the zero value drops.
+type SlowConsumerPolicy int
+
+const (
+	// PolicyDropNewest drops the message being delivered, it stays in the waiter and is resent later
+	PolicyDropNewest SlowConsumerPolicy = iota
+	// PolicyBlock waits for room in the buffer until Timeout. Delivery runs on a shared dispatcher worker,
+	// so one stuck client stalls every client of its shard for up to Timeout per message.
+	PolicyBlock
+	// PolicyDropOldest drops the oldest buffered message to make room
+	PolicyDropOldest
+	// PolicyDisconnect closes the connection with CloseCode
+	PolicyDisconnect
+)
+
+var ErrSendBufferFull = errors.New("send buffer full")
+
+type BackpressureOption struct {
+	Policy    SlowConsumerPolicy
+	Timeout   time.Duration // PolicyBlock wait, default writeWait
+	CloseCode int           // PolicyDisconnect close code, default websocket.CloseTryAgainLater
+}
+
+var defaultBackpressureOption = &BackpressureOption{
+	Policy: PolicyDropNewest,
+}
+
+// Deliver queues data on the lane of its event Priority, see DeliverPriority.
+func (c *Client) Deliver(data []byte) error {
+	return c.DeliverPriority(priorityOf(data), data)
+}
+
+// DeliverPriority queues data for the websocket writer, applying the slow consumer policy when the lane is full.
+// Dropped channel messages are still in the waiter hash, so they will be resent later.
+func (c *Client) DeliverPriority(priority Priority, data []byte) error {
+	lane := c.lanes[priority.lane()]
+	select {
+	case <-c.Ctx.Done():
+		return ErrClientClosed
+	case lane <- data:
+		return nil
+	default:
+	}
+
+	option := c.Backpressure
+	if option == nil {
+		option = defaultBackpressureOption
+	}
+
+	switch option.Policy {
+	case PolicyDropOldest:
+		select {
+		case <-lane:
+		default:
+		}
+		select {
+		case lane <- data:
+			return nil
+		default:
+			return ErrSendBufferFull
+		}
+	case PolicyDisconnect:
+		code := option.CloseCode
+		if code == 0 {
+			code = websocket.CloseTryAgainLater
+		}
+		c.Close(code, "slow consumer")
+		return ErrSendBufferFull
+	case PolicyBlock:
+		timeout := option.Timeout
+		if timeout <= 0 {
+			timeout = writeWait
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case lane <- data:
+			return nil
+		case <-c.Ctx.Done():
+			return ErrClientClosed
+		case <-timer.C:
+			return ErrSendBufferFull
+		}
+	default:
+		return ErrSendBufferFull
+	}
+}
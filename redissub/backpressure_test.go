@@ -0,0 +1,90 @@
+package redissub
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func fillLane(t *testing.T, c *Client, priority Priority) {
+	t.Helper()
+	for i := 0; i < bufSize; i++ {
+		if err := c.DeliverPriority(priority, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("deliver %d: %v", i, err)
+		}
+	}
+}
+
+func TestDeliverDropNewestByDefault(t *testing.T) {
+	c := newTestClient()
+	fillLane(t, c, PriorityNormal)
+
+	if err := c.DeliverPriority(PriorityNormal, []byte("new")); err != ErrSendBufferFull {
+		t.Fatalf("err = %v, want ErrSendBufferFull", err)
+	}
+	frames := drain(c)
+	if len(frames) != bufSize || frames[0] != "0" || frames[bufSize-1] != fmt.Sprint(bufSize-1) {
+		t.Fatalf("buffer changed: %d frames, first %q last %q", len(frames), frames[0], frames[len(frames)-1])
+	}
+	// other lanes have their own room
+	if err := c.DeliverPriority(PriorityHigh, []byte("high")); err != nil {
+		t.Fatalf("high lane: %v", err)
+	}
+}
+
+func TestDeliverDropOldest(t *testing.T) {
+	c := newTestClient()
+	c.Backpressure = &BackpressureOption{Policy: PolicyDropOldest}
+	fillLane(t, c, PriorityNormal)
+
+	if err := c.DeliverPriority(PriorityNormal, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	frames := drain(c)
+	if len(frames) != bufSize || frames[0] != "1" || frames[bufSize-1] != "new" {
+		t.Fatalf("%d frames, first %q last %q, want the oldest dropped", len(frames), frames[0], frames[len(frames)-1])
+	}
+}
+
+func TestDeliverBlock(t *testing.T) {
+	c := newTestClient()
+	c.Backpressure = &BackpressureOption{Policy: PolicyBlock, Timeout: 20 * time.Millisecond}
+	fillLane(t, c, PriorityNormal)
+
+	start := time.Now()
+	if err := c.DeliverPriority(PriorityNormal, []byte("late")); err != ErrSendBufferFull {
+		t.Fatalf("err = %v, want ErrSendBufferFull after the timeout", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Fatalf("returned after %v, before the timeout", waited)
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		c.nextMessage()
+	}()
+	if err := c.DeliverPriority(PriorityNormal, []byte("fits")); err != nil {
+		t.Fatalf("err = %v once the writer made room", err)
+	}
+}
+
+func TestDeliverDisconnect(t *testing.T) {
+	c, peer := newTestConn(t, "u1", &SolidOption{})
+	c.Backpressure = &BackpressureOption{Policy: PolicyDisconnect}
+	fillLane(t, c, PriorityNormal)
+
+	if err := c.DeliverPriority(PriorityNormal, []byte("new")); err != ErrSendBufferFull {
+		t.Fatalf("err = %v, want ErrSendBufferFull", err)
+	}
+	if code := readClose(t, peer); code != websocket.CloseTryAgainLater {
+		t.Fatalf("close code = %d, want %d", code, websocket.CloseTryAgainLater)
+	}
+	if closeErr, ok := c.Err().(*websocket.CloseError); !ok || closeErr.Code != websocket.CloseTryAgainLater {
+		t.Fatalf("Err = %v", c.Err())
+	}
+	if err := c.DeliverPriority(PriorityNormal, []byte("after")); err != ErrClientClosed {
+		t.Fatalf("deliver after disconnect = %v, want ErrClientClosed", err)
+	}
+}
@@ -29,7 +29,8 @@ const (
 	// Send buffer size
 	bufSize = 256
 
-	ackEvent = "ack"
+	ackEvent  = "ack"
+	nackEvent = "nack"
 )
 
 type (
@@ -40,9 +41,12 @@ type (
 		conn *websocket.Conn
 		// The channel subId map
 		SubIds map[string]int64
-		// Buffered channel of outbound messages.
+		// Buffered channel of outbound messages, the normal priority lane.
 		Send chan []byte
-		// ctx
+		// Outbound lanes by priority, lanes[laneNormal] is Send
+		lanes   [laneCount]chan []byte
+		starved int
+		// Connection context, canceled once when the connection ends
 		Ctx context.Context
 
 		// uuid
@@ -50,14 +54,28 @@ type (
 
 		Solid *Solid
 
-		mu sync.Mutex
+		// Slow consumer policy, nil drops the newest message
+		Backpressure *BackpressureOption
+
+		// Application metadata, matched by broadcast options
+		metadata map[string]string
+		// Subscription filters by channel, also applied when pulling offline messages
+		filters map[string]*Filter
+
+		mu        sync.Mutex
+		cancel    context.CancelFunc
+		err       error
+		endOnce   sync.Once
+		closeOnce sync.Once
 	}
 
 	HandlerSubId func(subId int64) error
 	GetSubId     func(channel string) int64
 )
 
+// MustNewClient creates a client whose Ctx keeps the values of ctx but lives until the connection ends.
 func MustNewClient(ctx context.Context, conn *websocket.Conn, id string, solidOption *SolidOption) *Client {
+	ctx, cancel := context.WithCancel(valueContext{ctx})
 	client := &Client{
 		Channels: []string{},
 		conn:     conn,
@@ -65,7 +83,14 @@ func MustNewClient(ctx context.Context, conn *websocket.Conn, id string, solidOp
 		Send:     make(chan []byte, bufSize),
 		Ctx:      ctx,
 		Id:       id,
+		cancel:   cancel,
+		metadata: map[string]string{},
+		filters:  map[string]*Filter{},
+	}
+	for i := range client.lanes {
+		client.lanes[i] = make(chan []byte, bufSize)
 	}
+	client.lanes[laneNormal] = client.Send
 	client.Solid = MustNewSolid(solidOption, client)
 
 	return client
@@ -81,6 +106,68 @@ func (c *Client) Subscribe(channel string) error {
 	return nil
 }
 
+func (c *Client) SetMeta(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metadata[key] = value
+}
+
+func (c *Client) Meta(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.metadata[key]
+}
+
+// MatchMeta reports whether the client metadata has every key value pair of match.
+func (c *Client) MatchMeta(match map[string]string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range match {
+		if c.metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// join subscribes the client to channel through pubSubClient and pulls offline messages for resend.
+func (c *Client) join(pubSubClient *PubSubClient, channel string, onMessage OnMessage, filter *Filter) error {
+	if err := c.Subscribe(channel); err != nil {
+		return err
+	}
+	if filter != nil {
+		c.mu.Lock()
+		c.filters[channel] = filter
+		c.mu.Unlock()
+	}
+	subId := pubSubClient.SubscribeWithFilter(c, channel, onMessage, filter)
+	c.BindChannelWithSubId(channel, subId)
+	GoSafe(func() {
+		c.Solid.PullOfflineMessage() // pull offline message to waiter for resend
+	})
+	return nil
+}
+
+// channels returns a copy of the subscribed channels.
+func (c *Client) channels() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.Channels...)
+}
+
+func (c *Client) subscribed(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Contains(c.Channels, channel)
+}
+
+// filter returns the subscription filter of channel, nil when it has none.
+func (c *Client) filter(channel string) *Filter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filters[channel]
+}
+
 func (c *Client) BindChannelWithSubId(channel string, subId int64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -101,16 +188,51 @@ func (c *Client) UnSubscribe(channel string) int64 {
 	subId := c.SubIds[channel]
 	c.mu.Lock()
 	delete(c.SubIds, channel)
+	delete(c.filters, channel)
 	c.mu.Unlock()
 	return subId
 }
 
-func (c *Client) close(pubSubClient *PubSubClient) {
-	for _, subId := range c.SubIds {
-		pubSubClient.UnSubscribe(subId)
+// Done is closed when the connection ends.
+func (c *Client) Done() <-chan struct{} {
+	return c.Ctx.Done()
+}
+
+// Err returns why the connection ended, nil while it is open.
+func (c *Client) Err() error {
+	select {
+	case <-c.Ctx.Done():
+		return c.err
+	default:
+		return nil
 	}
+}
+
+// Close sends a close frame with code and reason, then closes the connection.
+func (c *Client) Close(code int, reason string) error {
+	err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
 	c.conn.Close()
-	c = nil
+	c.end(&websocket.CloseError{Code: code, Text: reason})
+	return err
+}
+
+// end records why the connection ended and cancels Ctx, only the first call counts.
+func (c *Client) end(err error) {
+	c.endOnce.Do(func() {
+		c.err = err
+		c.cancel()
+	})
+}
+
+func (c *Client) close(pubSubClient *PubSubClient) {
+	c.closeOnce.Do(func() {
+		c.end(ErrClientClosed)
+		for _, subId := range c.SubIds {
+			pubSubClient.UnSubscribe(subId)
+		}
+		c.conn.Close()
+		pubSubClient.removeClient(c)
+	})
 }
 
 func (c *Client) ReadPump(pubSubClient *PubSubClient) {
@@ -120,53 +242,82 @@ func (c *Client) ReadPump(pubSubClient *PubSubClient) {
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
-	for {
+	for c.Ctx.Err() == nil {
 		_, message, err := c.conn.ReadMessage()
 
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
+			c.end(err)
 			break
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
 		var event Event
 		_ = jsoniter.Unmarshal(message, &event)
+		pubSubClient.inboundChain(func(client *Client, event *Event) {
+			client.handleEvent(pubSubClient, event)
+		})(c, &event)
+	}
+}
 
-		if event.EventName == "ping" {
-			var pong Event
-			pong.EventName = "pong"
-			pongByte, _ := jsoniter.Marshal(&pong)
-			c.Send <- pongByte
-		} else {
-			if onMessageWrapper, ok := subScribeFuncs[event.EventName]; ok {
-				onMessage := onMessageWrapper.OnMessage
-				channelKeyFun := onMessageWrapper.ChannelFun
-				var channel = event.EventName
-				if channelKeyFun != nil {
-					channel = channelKeyFun(c.Ctx, []byte(event.Data))
+// handleEvent dispatches ping, subscribe, resync, ack and nack frames, it is the end of the inbound middleware chain.
+func (c *Client) handleEvent(pubSubClient *PubSubClient, event *Event) {
+	var err error
+	if event.EventName == "ping" {
+		var pong Event
+		pong.EventName = "pong"
+		pong.Priority = PriorityControl
+		pongByte, _ := jsoniter.Marshal(&pong)
+		c.DeliverPriority(PriorityControl, pongByte)
+	} else {
+		if onMessageWrapper, ok := subScribeFuncs[event.EventName]; ok {
+			onMessage := onMessageWrapper.OnMessage
+			channelKeyFun := onMessageWrapper.ChannelFun
+			var channel = event.EventName
+			if channelKeyFun != nil {
+				channel = channelKeyFun(c.Ctx, []byte(event.Data))
+			}
+			var filter *Filter
+			if event.Filter != "" {
+				filter, err = CompileFilter(event.Filter)
+				if err != nil {
+					log.Printf("subscribe %v error: %v", channel, err)
+					return
 				}
-
-				GoSafe(func() {
-					err = c.Subscribe(channel)
-					if err != nil {
-						return
-					}
-					subId := pubSubClient.Subscribe(c, channel, onMessage)
-					c.BindChannelWithSubId(channel, subId)
-					GoSafe(func() {
-						c.Solid.PullOfflineMessage() // pull offline message to waiter for resend
-					})
-				})
 			}
 
-			if event.EventName == ackEvent {
-				var ackEvent Event
-				_ = jsoniter.Unmarshal([]byte(event.Data), &ackEvent)
-				c.Solid.Ack(context.Background(), &ackEvent)
+			GoSafe(func() {
+				c.join(pubSubClient, channel, onMessage, filter)
+			})
+		}
+
+		if event.EventName == resyncEvent {
+			var request ResyncRequest
+			_ = jsoniter.Unmarshal([]byte(event.Data), &request)
+			GoSafe(func() {
+				if _, err := c.Solid.Resync(c.Ctx, request.Channel, request.Seq); err != nil {
+					log.Printf("resync %v error: %v", request.Channel, err)
+				}
+			})
+		}
+
+		if event.EventName == nackEvent {
+			var request NackRequest
+			_ = jsoniter.Unmarshal([]byte(event.Data), &request)
+			if err := c.Solid.Nack(context.Background(), &request); err != nil {
+				log.Printf("nack %v error: %v", request.Id, err)
 			}
+		}
 
+		if event.EventName == ackEvent {
+			var request AckRequest
+			_ = jsoniter.Unmarshal([]byte(event.Data), &request)
+			if _, err := c.Solid.AckBatch(context.Background(), &request); err != nil {
+				log.Printf("ack error: %v", err)
+			}
 		}
+
 	}
 }
 
@@ -184,43 +335,75 @@ func (c *Client) writePump(pubSubClient *PubSubClient) {
 	}()
 
 	for {
-		select {
-		case message, ok := <-c.Send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		message, ok := c.nextMessage()
+		if !ok {
+			select {
+			case message = <-c.lanes[laneControl]:
+			case message = <-c.lanes[laneHigh]:
+			case message, ok = <-c.Send:
+				if !ok {
+					// The hub closed the channel.
+					c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+					return
+				}
+			case message = <-c.lanes[laneBulk]:
+			case <-c.Ctx.Done():
 				return
+			case <-ticker.C:
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+				continue
 			}
+		}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		w, err := c.conn.NextWriter(websocket.TextMessage)
+		if err != nil {
+			return
+		}
+		w.Write(message)
 
-			// Add queued chat messages to the current websocket message.
-			c.WriteData(w)
+		// Add queued chat messages to the current websocket message.
+		c.WriteData(w)
 
-			if err := w.Close(); err != nil {
-				return
-			}
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
+		if err := w.Close(); err != nil {
+			return
 		}
 	}
 }
 
+// valueContext keeps the values of the request context without its cancellation,
+// net/http cancels the request context as soon as ServeWs returns.
+type valueContext struct {
+	context.Context
+}
+
+func (valueContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (valueContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueContext) Err() error {
+	return nil
+}
+
 func (c *Client) WriteData(w io.WriteCloser) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	n := len(c.Send)
+	n := c.queued()
 	for i := 0; i < n; i++ {
+		message, ok := c.nextMessage()
+		if !ok {
+			return
+		}
 		w.Write(newline)
-		w.Write(<-c.Send)
+		w.Write(message)
 	}
 	return
 }
@@ -0,0 +1,82 @@
+package redissub
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientClose(t *testing.T) {
+	c, peer := newTestConn(t, "u1", &SolidOption{})
+	if c.Err() != nil {
+		t.Fatalf("Err = %v while open", c.Err())
+	}
+	select {
+	case <-c.Done():
+		t.Fatal("Done closed while open")
+	default:
+	}
+
+	c.Close(4000, "bye")
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("Done not closed after Close")
+	}
+	closeErr, ok := c.Err().(*websocket.CloseError)
+	if !ok || closeErr.Code != 4000 || closeErr.Text != "bye" {
+		t.Fatalf("Err = %v, want the close code", c.Err())
+	}
+	if code := readClose(t, peer); code != 4000 {
+		t.Fatalf("peer close code = %d, want 4000", code)
+	}
+
+	// only the first reason counts
+	c.end(ErrClientClosed)
+	if c.Err() != closeErr {
+		t.Fatalf("Err = %v after a second end", c.Err())
+	}
+}
+
+type ctxKey struct{}
+
+func TestClientContextKeepsValues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "tenant"))
+	c := MustNewClient(ctx, nil, "u1", &SolidOption{})
+	cancel() // net/http cancels the request context when ServeWs returns
+
+	if c.Ctx.Err() != nil {
+		t.Fatalf("client context ended with its request: %v", c.Ctx.Err())
+	}
+	if got := c.Ctx.Value(ctxKey{}); got != "tenant" {
+		t.Fatalf("value = %v, want the request value", got)
+	}
+}
+
+func TestClientEndsWhenPeerLeaves(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	clients := make(chan *Client, 1)
+	p.OnConnect = func(r *http.Request, client *Client) {
+		clients <- client
+	}
+	server := newTestServer(t, p)
+
+	conn := dialTest(t, server, "id=u1")
+	c := <-clients
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+
+	select {
+	case <-c.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Done not closed after the peer left")
+	}
+	if c.Err() == nil {
+		t.Fatal("Err = nil after the peer left")
+	}
+	waitClients(t, p, 0)
+}
@@ -0,0 +1,102 @@
+package redissub
+
+import (
+	"context"
+	jsoniter "github.com/json-iterator/go"
+	"log"
+	"time"
+)
+
+type (
+	// EventHandler consumes channel messages in process, a durable consumer acks the message when it returns nil.
+	EventHandler func(ctx context.Context, event *Event) error
+
+	// Consumer is a named durable subscriber, its offset and pending messages live in the same
+	// waiter, receiver and offset keys as a websocket client with the same id.
+	Consumer struct {
+		Id      string
+		Channel string
+		online  *Online
+		offline *OffLine
+	}
+)
+
+// SubscribeFunc calls handler for every message published on channel while subscribed.
+// Outbound middlewares are not applied, they wrap websocket deliveries.
+func (p *PubSubClient) SubscribeFunc(channel string, handler EventHandler) int64 {
+	return p.subscribeListener(&Listener{Channel: channel, Handler: handler})
+}
+
+// SubscribeDurableFunc is SubscribeFunc with a named consumer: messages published while no node
+// was subscribed with consumerId are replayed first, and failed ones are retried on the next subscribe.
+func (p *PubSubClient) SubscribeDurableFunc(channel, consumerId string, handler EventHandler) int64 {
+	rdb := p.SolidOption.Rdb
+	expireTime := p.SolidOption.ExpireTime
+	consumer := &Consumer{
+		Id:      consumerId,
+		Channel: channel,
+		online:  newOnline(rdb, expireTime, p.SolidOption.DeadLetterTTL, channel, consumerId),
+		offline: &OffLine{
+			ExpireTime: expireTime,
+			Rdb:        rdb,
+			Key:        GenOfflineKey(channel),
+		},
+	}
+
+	// read the offset before subscribing so nothing published in between is skipped
+	offset := consumer.online.Offset.Offset(context.Background())
+	listener := &Listener{Channel: channel, Handler: handler, Consumer: consumer}
+	// the catch up is queued ahead of every live message, it reads the log once the listener is registered
+	subscribed := make(chan struct{})
+	p.Dispatcher.Dispatch(listener.key(), func() {
+		<-subscribed
+		consumer.catchUp(context.Background(), offset, handler)
+	})
+	subId := p.subscribeListener(listener)
+	close(subscribed)
+	return subId
+}
+
+// consume runs handler for one message, a durable consumer acks it on success and keeps it pending on error.
+func consume(ctx context.Context, c *Consumer, handler EventHandler, data []byte) {
+	var event Event
+	if err := jsoniter.Unmarshal(data, &event); err != nil {
+		return
+	}
+	if c == nil {
+		if err := handler(ctx, &event); err != nil {
+			log.Printf("consumer handler error: %v", err)
+		}
+		return
+	}
+
+	if c.online.Receiver.IsReceived(ctx, data) {
+		return
+	}
+	if err := handler(ctx, &event); err != nil {
+		log.Printf("consumer %v handler error: %v", c.Id, err)
+		c.online.Waiter.Push(ctx, data)
+		return
+	}
+	c.online.Ack(ctx, &event)
+}
+
+func (c *Consumer) catchUp(ctx context.Context, offset int64, handler EventHandler) {
+	pending := []string{}
+	for _, item := range c.online.Waiter.All(ctx) {
+		pending = append(pending, item.(string))
+	}
+	datas, err := c.offline.MessageByOffset(ctx, offset)
+	if err == nil {
+		pending = append(pending, datas...)
+	}
+
+	now := time.Now()
+	for _, item := range pending {
+		data := []byte(item)
+		if isExpired(data, now) {
+			continue
+		}
+		consume(ctx, c, handler, data)
+	}
+}
@@ -0,0 +1,101 @@
+package redissub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (r *recorder) add(data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = append(r.seen, data)
+}
+
+func (r *recorder) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		seen := append([]string{}, r.seen...)
+		r.mu.Unlock()
+		if len(seen) >= n {
+			return seen
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("handled %v, want %d messages", seen, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSubscribeDurableFuncReplaysInOrder(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	// published while no node had the consumer subscribed
+	for i := 0; i < 3; i++ {
+		p.Publish(ctx, "orders", &Event{Data: fmt.Sprint("old", i)})
+	}
+
+	handled := &recorder{}
+	p.SubscribeDurableFunc("orders", "billing", func(ctx context.Context, event *Event) error {
+		handled.add(event.Data)
+		return nil
+	})
+	waitChannels(t, m, "orders", "redissub:control")
+	live := &Event{Data: "live"}
+	p.Publish(ctx, "orders", live)
+
+	handled.wait(t, 4)
+	time.Sleep(50 * time.Millisecond)
+	if got := fmt.Sprint(handled.wait(t, 4)); got != "[old0 old1 old2 live]" {
+		t.Fatalf("handled %v, want the replay before the live message", got)
+	}
+	if offset := newOnline(rdb, time.Hour, 0, "orders", "billing").Offset.Offset(ctx); offset != live.Seq {
+		t.Fatalf("offset = %d, want %d", offset, live.Seq)
+	}
+}
+
+func TestSubscribeDurableFuncRetriesFailed(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	handled := &recorder{}
+	fail := true
+	var mu sync.Mutex
+	handler := func(ctx context.Context, event *Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if event.Data == "flaky" && fail {
+			fail = false
+			return errors.New("downstream unavailable")
+		}
+		handled.add(event.Data)
+		return nil
+	}
+	id := p.SubscribeDurableFunc("orders", "billing", handler)
+	waitChannels(t, m, "orders", "redissub:control")
+	p.Publish(ctx, "orders", &Event{Data: "flaky"})
+	p.Publish(ctx, "orders", &Event{Data: "fine"})
+	handled.wait(t, 1)
+	p.UnSubscribe(id)
+
+	// the next subscribe retries the failed message, the handled one is not repeated
+	p.SubscribeDurableFunc("orders", "billing", handler)
+	handled.wait(t, 2)
+	time.Sleep(50 * time.Millisecond)
+	if got := fmt.Sprint(handled.wait(t, 2)); got != "[fine flaky]" {
+		t.Fatalf("handled %v", got)
+	}
+}
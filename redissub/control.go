@@ -0,0 +1,132 @@
+package redissub
+
+import (
+	"context"
+	jsoniter "github.com/json-iterator/go"
+	"log"
+	"time"
+)
+
+const (
+	// controlChannel carries node to node commands, every node subscribes to it
+	controlChannel = "redissub:control"
+	// BroadcastChannel is the Channel of broadcast events, used for their waiter when persisted
+	BroadcastChannel = "redissub:broadcast"
+
+	controlBroadcast   = "broadcast"
+	controlSubscribe   = "subscribe"
+	controlUnsubscribe = "unsubscribe"
+)
+
+type (
+	BroadcastOptions struct {
+		Match   map[string]string // only clients whose metadata has all these values, nil reaches everyone
+		Persist bool              // keep the event in each client waiter until acked
+	}
+
+	controlMessage struct {
+		Type     string            `json:"Type"`
+		Event    *Event            `json:"Event,omitempty"`
+		Match    map[string]string `json:"Match,omitempty"`
+		Persist  bool              `json:"Persist,omitempty"`
+		ClientId string            `json:"ClientId,omitempty"`
+		Channel  string            `json:"Channel,omitempty"`
+	}
+)
+
+// Broadcast delivers event to every connected client on every node, filtered by opts.Match.
+func (p *PubSubClient) Broadcast(ctx context.Context, event *Event, opts BroadcastOptions) (MessageID, error) {
+	if event == nil {
+		return "", ErrNilEvent
+	}
+	stampEvent(event)
+	event.Channel = BroadcastChannel
+	err := p.publishControl(ctx, &controlMessage{
+		Type:    controlBroadcast,
+		Event:   event,
+		Match:   opts.Match,
+		Persist: opts.Persist,
+	})
+	if err != nil {
+		return "", err
+	}
+	return MessageID(event.Id), nil
+}
+
+// SubscribeClient joins every connection of clientId, on any node, to channel,
+// the same way a subscribe event from the client does, including the offline message pull.
+func (p *PubSubClient) SubscribeClient(ctx context.Context, clientId, channel string) error {
+	return p.publishControl(ctx, &controlMessage{
+		Type:     controlSubscribe,
+		ClientId: clientId,
+		Channel:  channel,
+	})
+}
+
+// UnsubscribeClient removes channel from every connection of clientId, on any node.
+func (p *PubSubClient) UnsubscribeClient(ctx context.Context, clientId, channel string) error {
+	return p.publishControl(ctx, &controlMessage{
+		Type:     controlUnsubscribe,
+		ClientId: clientId,
+		Channel:  channel,
+	})
+}
+
+func (p *PubSubClient) publishControl(ctx context.Context, message *controlMessage) error {
+	data, err := jsoniter.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return p.Publisher.Publish(ctx, controlChannel, data).Err()
+}
+
+func (p *PubSubClient) handleControl(payLoad []byte) {
+	var message controlMessage
+	if err := jsoniter.Unmarshal(payLoad, &message); err != nil {
+		log.Printf("control message error: %v", err)
+		return
+	}
+
+	switch message.Type {
+	case controlBroadcast:
+		p.broadcastLocal(&message)
+	case controlSubscribe:
+		for _, client := range p.clientsById(message.ClientId) {
+			c := client
+			GoSafe(func() {
+				c.join(p, message.Channel, deliverOnMessage, nil)
+			})
+		}
+	case controlUnsubscribe:
+		for _, client := range p.clientsById(message.ClientId) {
+			if subId := client.UnSubscribe(message.Channel); subId != 0 {
+				p.UnSubscribe(subId)
+			}
+		}
+	}
+}
+
+func (p *PubSubClient) broadcastLocal(message *controlMessage) {
+	if message.Event == nil || message.Event.Expired(time.Now()) {
+		return
+	}
+	frame, err := jsoniter.Marshal(message.Event)
+	if err != nil {
+		return
+	}
+	for _, client := range p.connectedClients() {
+		c := client
+		if !c.MatchMeta(message.Match) {
+			continue
+		}
+		p.Dispatcher.Dispatch(c.Id, func() {
+			deliver := p.outboundChain(func(client *Client, channel string, data []byte) {
+				if message.Persist {
+					client.Solid.Push(context.Background(), channel, data)
+				}
+				client.Deliver(data)
+			})
+			deliver(c, BroadcastChannel, frame)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package redissub
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBroadcastMatchAndPersist(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	p.OnConnect = func(r *http.Request, client *Client) {
+		client.SetMeta("role", r.URL.Query().Get("role"))
+	}
+	server := newTestServer(t, p)
+	admin := dialTest(t, server, "id=a&role=admin")
+	user := dialTest(t, server, "id=b&role=user")
+	waitClients(t, p, 2)
+	waitChannels(t, m, "redissub:control", GenInboxChannel("a"), GenInboxChannel("b"))
+	ctx := context.Background()
+
+	id, err := p.Broadcast(ctx, &Event{EventName: "notice"}, BroadcastOptions{
+		Match:   map[string]string{"role": "admin"},
+		Persist: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readEvent(t, admin, eventNamed("notice"))
+	if got.Id != string(id) || got.Channel != BroadcastChannel {
+		t.Fatalf("admin got %+v", got)
+	}
+	expectNoEvent(t, user, 200*time.Millisecond, eventNamed("notice"))
+
+	// persisted broadcasts wait for an ack like channel messages
+	if waiting := waiterIds(t, rdb, BroadcastChannel, "a"); !waiting[string(id)] {
+		t.Fatalf("admin waiter = %v, want the broadcast", waiting)
+	}
+	if waiting := waiterIds(t, rdb, BroadcastChannel, "b"); len(waiting) != 0 {
+		t.Fatalf("user waiter = %v", waiting)
+	}
+}
+
+func TestBroadcastEveryone(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	server := newTestServer(t, p)
+	first := dialTest(t, server, "id=a")
+	second := dialTest(t, server, "id=b")
+	waitClients(t, p, 2)
+	waitChannels(t, m, "redissub:control", GenInboxChannel("a"), GenInboxChannel("b"))
+
+	if _, err := p.Broadcast(context.Background(), &Event{EventName: "notice"}, BroadcastOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	readEvent(t, first, eventNamed("notice"))
+	readEvent(t, second, eventNamed("notice"))
+	if waiting := waiterIds(t, rdb, BroadcastChannel, "a"); len(waiting) != 0 {
+		t.Fatalf("waiter = %v, broadcast without Persist kept", waiting)
+	}
+}
+
+func waitSubscribed(t *testing.T, p *PubSubClient, clientId, channel string, want bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		clients := p.clientsById(clientId)
+		if len(clients) == 1 && clients[0].subscribed(channel) == want && (len(p.listeners(channel)) > 0) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s subscribed to %s is not %v", clientId, channel, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSubscribeClient(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	server := newTestServer(t, p)
+	target := dialTest(t, server, "id=u1")
+	other := dialTest(t, server, "id=u2")
+	waitClients(t, p, 2)
+	waitChannels(t, m, "redissub:control", GenInboxChannel("u1"), GenInboxChannel("u2"))
+	ctx := context.Background()
+
+	// published before the subscribe, pulled into the waiter like on a client subscribe
+	missed, _ := p.Publish(ctx, "room", &Event{EventName: "missed"})
+
+	if err := p.SubscribeClient(ctx, "u1", "room"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, p, "u1", "room", true)
+	waitChannels(t, m, "redissub:control", GenInboxChannel("u1"), GenInboxChannel("u2"), "room")
+
+	p.Publish(ctx, "room", &Event{EventName: "live"})
+	readEvent(t, target, eventNamed("live"))
+	expectNoEvent(t, other, 100*time.Millisecond, eventNamed("live"))
+	deadline := time.Now().Add(2 * time.Second)
+	for !waiterIds(t, rdb, "room", "u1")[string(missed)] {
+		if time.Now().After(deadline) {
+			t.Fatal("offline message not pulled on subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := p.UnsubscribeClient(ctx, "u1", "room"); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, p, "u1", "room", false)
+	waitChannels(t, m, "redissub:control", GenInboxChannel("u1"), GenInboxChannel("u2"))
+	p.Publish(ctx, "room", &Event{EventName: "after"})
+	expectNoEvent(t, target, 100*time.Millisecond, eventNamed("after"))
+}
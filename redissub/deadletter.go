@@ -0,0 +1,193 @@
+package redissub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	red "github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	deadLetterPrefix = "redissub:deadletter:stream:%v:%v"
+
+	// deadLetterMaxLen approximately bounds each dead letter stream
+	deadLetterMaxLen = 10000
+
+	defaultDeadLetterTTL = 7 * 24 * time.Hour
+
+	DeadLetterMaxAttempts = "max_attempts"
+	DeadLetterExpired     = "expired"
+	DeadLetterRejected    = "rejected"
+)
+
+var ErrDeadLetterExpired = errors.New("dead letter expired")
+
+type (
+	// DeadLetters is the stream of messages a client never acked, by channel and client.
+	// It outlives the waiter: entries stay until they are requeued or purged, or the stream expires
+	// ExpireTime after its last entry, default 7 days.
+	DeadLetters struct {
+		Key        string
+		Rdb        *red.Client
+		ExpireTime time.Duration
+	}
+
+	DeadLetter struct {
+		Id       string `json:"Id"` // stream entry id
+		Reason   string `json:"Reason"`
+		Attempts int    `json:"Attempts"`
+		Time     int64  `json:"Time"` // unix milliseconds the message was dead lettered
+		Event    *Event `json:"Event"`
+		data     string
+	}
+)
+
+func (d *DeadLetters) Add(ctx context.Context, data []byte, reason string, attempts int) error {
+	ttl := d.ExpireTime
+	if ttl <= 0 {
+		ttl = defaultDeadLetterTTL
+	}
+	_, err := d.Rdb.TxPipelined(ctx, func(pipe red.Pipeliner) error {
+		pipe.XAdd(ctx, &red.XAddArgs{
+			Stream: d.Key,
+			MaxLen: deadLetterMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"Reason":   reason,
+				"Attempts": attempts,
+				"Time":     time.Now().UnixMilli(),
+				"Event":    string(data),
+			},
+		})
+		pipe.Expire(ctx, d.Key, ttl)
+		return nil
+	})
+	return err
+}
+
+// List returns up to count dead letters after the entry id after, oldest first, "" starts at the oldest.
+func (d *DeadLetters) List(ctx context.Context, after string, count int64) ([]*DeadLetter, error) {
+	start := "-"
+	if after != "" {
+		start = nextStreamId(after)
+	}
+	if count <= 0 {
+		count = defaultHistoryLimit
+	}
+	messages, err := d.Rdb.XRangeN(ctx, d.Key, start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0, len(messages))
+	for _, message := range messages {
+		letters = append(letters, newDeadLetter(message))
+	}
+	return letters, nil
+}
+
+// nextStreamId is the smallest stream id after id, an exclusive XRANGE start without the Redis 6.2 "(" form.
+func nextStreamId(id string) string {
+	ms, seq := id, "0"
+	if i := strings.IndexByte(id, '-'); i >= 0 {
+		ms, seq = id[:i], id[i+1:]
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return id // let redis reject it
+	}
+	if n == math.MaxUint64 {
+		t, err := strconv.ParseUint(ms, 10, 64)
+		if err != nil {
+			return id
+		}
+		return strconv.FormatUint(t+1, 10) + "-0"
+	}
+	return ms + "-" + strconv.FormatUint(n+1, 10)
+}
+
+// Get returns the dead letter with entry id, nil when there is none.
+func (d *DeadLetters) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	messages, err := d.Rdb.XRange(ctx, d.Key, id, id).Result()
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return newDeadLetter(messages[0]), nil
+}
+
+// Del removes entries, no ids removes the whole stream.
+func (d *DeadLetters) Del(ctx context.Context, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return d.Rdb.Del(ctx, d.Key).Result()
+	}
+	return d.Rdb.XDel(ctx, d.Key, ids...).Result()
+}
+
+func newDeadLetter(message red.XMessage) *DeadLetter {
+	letter := &DeadLetter{Id: message.ID}
+	letter.Reason, _ = message.Values["Reason"].(string)
+	attempts, _ := message.Values["Attempts"].(string)
+	letter.Attempts, _ = strconv.Atoi(attempts)
+	deadAt, _ := message.Values["Time"].(string)
+	letter.Time, _ = strconv.ParseInt(deadAt, 10, 64)
+	letter.data, _ = message.Values["Event"].(string)
+	var event Event
+	if jsoniter.Unmarshal([]byte(letter.data), &event) == nil {
+		letter.Event = &event
+	}
+	return letter
+}
+
+// deadLetter moves a waiter message of channel to the dead letter stream, it stays in the waiter when that fails.
+func (r *Online) deadLetter(ctx context.Context, event *Event, data []byte, reason string, attempts int) error {
+	if err := r.DeadLetters.Add(ctx, data, reason, attempts); err != nil {
+		return err
+	}
+	r.Waiter.Del(ctx, event)
+	r.Attempts.Del(ctx, event.Id)
+	return nil
+}
+
+// DeadLetters lists the dead letters of clientId on channel, see DeadLetters.List.
+func (p *PubSubClient) DeadLetters(ctx context.Context, channel, clientId, after string, count int64) ([]*DeadLetter, error) {
+	return p.online(channel, clientId).DeadLetters.List(ctx, after, count)
+}
+
+func (p *PubSubClient) DeadLetter(ctx context.Context, channel, clientId, id string) (*DeadLetter, error) {
+	return p.online(channel, clientId).DeadLetters.Get(ctx, id)
+}
+
+// RequeueDeadLetter puts a dead letter back in the client waiter with fresh attempts,
+// it is resent by the connected client like any unacked message.
+// An expired event is not requeued, it returns ErrDeadLetterExpired and the dead letter stays.
+func (p *PubSubClient) RequeueDeadLetter(ctx context.Context, channel, clientId, id string) (bool, error) {
+	online := p.online(channel, clientId)
+	letter, err := online.DeadLetters.Get(ctx, id)
+	if err != nil || letter == nil || letter.Event == nil {
+		return false, err
+	}
+	if letter.Event.Expired(time.Now()) {
+		return false, ErrDeadLetterExpired
+	}
+	online.Attempts.Del(ctx, letter.Event.Id)
+	online.Waiter.Push(ctx, []byte(letter.data))
+	_, err = online.DeadLetters.Del(ctx, id)
+	return err == nil, err
+}
+
+// PurgeDeadLetters removes the dead letters with ids, all of them when no id is given.
+func (p *PubSubClient) PurgeDeadLetters(ctx context.Context, channel, clientId string, ids ...string) (int64, error) {
+	return p.online(channel, clientId).DeadLetters.Del(ctx, ids...)
+}
+
+func (p *PubSubClient) online(channel, clientId string) *Online {
+	return newOnline(p.SolidOption.Rdb, p.SolidOption.ExpireTime, p.SolidOption.DeadLetterTTL, channel, clientId)
+}
+
+func GenDeadLetterKey(channel, id string) string {
+	return fmt.Sprintf(deadLetterPrefix, channel, id)
+}
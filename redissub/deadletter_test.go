@@ -0,0 +1,125 @@
+package redissub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestNextStreamId(t *testing.T) {
+	for _, tc := range []struct {
+		id, want string
+	}{
+		{"1700000000000-0", "1700000000000-1"},
+		{"1700000000000-41", "1700000000000-42"},
+		{"1700000000000", "1700000000000-1"},
+		{"5-18446744073709551615", "6-0"},
+	} {
+		if got := nextStreamId(tc.id); got != tc.want {
+			t.Errorf("nextStreamId(%q) = %q, want %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestDeadLettersListPages(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	online := p.online("orders", "u1")
+	for _, id := range []string{"a", "b", "c"} {
+		frame, _ := jsoniter.Marshal(&Event{Id: id})
+		if err := online.DeadLetters.Add(ctx, frame, DeadLetterRejected, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := []string{}
+	after := ""
+	for {
+		letters, err := p.DeadLetters(ctx, "orders", "u1", after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) == 0 {
+			break
+		}
+		for _, letter := range letters {
+			ids = append(ids, letter.Event.Id)
+		}
+		after = letters[len(letters)-1].Id
+	}
+	if got := fmt.Sprint(ids); got != "[a b c]" {
+		t.Fatalf("paged ids = %v", got)
+	}
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	online := p.online("orders", "u1")
+	live, _ := jsoniter.Marshal(&Event{Id: "live"})
+	expired, _ := jsoniter.Marshal(&Event{Id: "expired", ExpiresAt: time.Now().Add(-time.Minute).UnixMilli()})
+	online.DeadLetters.Add(ctx, live, DeadLetterMaxAttempts, 3)
+	online.DeadLetters.Add(ctx, expired, DeadLetterRejected, 1)
+	letters, err := online.DeadLetters.List(ctx, "", 0)
+	if err != nil || len(letters) != 2 {
+		t.Fatalf("letters = %v, %v", letters, err)
+	}
+
+	if ok, err := p.RequeueDeadLetter(ctx, "orders", "u1", letters[0].Id); !ok || err != nil {
+		t.Fatalf("requeue live = %v, %v", ok, err)
+	}
+	if ok, err := p.RequeueDeadLetter(ctx, "orders", "u1", letters[1].Id); ok || err != ErrDeadLetterExpired {
+		t.Fatalf("requeue expired = %v, %v, want ErrDeadLetterExpired", ok, err)
+	}
+
+	if got := waiterIds(t, rdb, "orders", "u1"); len(got) != 1 || !got["live"] {
+		t.Fatalf("waiter = %v, want only live", got)
+	}
+	left, _ := online.DeadLetters.List(ctx, "", 0)
+	if len(left) != 1 || left[0].Event.Id != "expired" {
+		t.Fatalf("dead letters left = %+v", left)
+	}
+}
+
+func TestDeadLettersExpire(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	frame, _ := jsoniter.Marshal(&Event{Id: "a"})
+	p.online("orders", "u1").DeadLetters.Add(ctx, frame, DeadLetterRejected, 1)
+	if ttl := m.TTL(GenDeadLetterKey("orders", "u1")); ttl != defaultDeadLetterTTL {
+		t.Fatalf("default ttl = %v, want %v", ttl, defaultDeadLetterTTL)
+	}
+
+	p.SolidOption.DeadLetterTTL = time.Hour
+	p.online("orders", "u2").DeadLetters.Add(ctx, frame, DeadLetterRejected, 1)
+	if ttl := m.TTL(GenDeadLetterKey("orders", "u2")); ttl != time.Hour {
+		t.Fatalf("ttl = %v, want DeadLetterTTL", ttl)
+	}
+}
+
+func TestDeadLetterKeepsWaiterOnError(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	pushWaiting(t, c, "orders", &Event{Id: "a", Channel: "orders"})
+	// a key of the wrong type fails the XADD
+	rdb.Set(ctx, GenDeadLetterKey("orders", "u1"), "not a stream", 0)
+
+	if err := c.Solid.Nack(ctx, &NackRequest{Id: "a", Channel: "orders", Mode: NackReject}); err == nil {
+		t.Fatal("reject succeeded without a dead letter")
+	}
+	if got := waiterIds(t, rdb, "orders", "u1"); !got["a"] {
+		t.Fatalf("waiter = %v, message dropped", got)
+	}
+}
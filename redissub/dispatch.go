@@ -0,0 +1,155 @@
+package redissub
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDispatchWorkers   = 16
+	defaultDispatchQueueSize = 1024
+)
+
+var (
+	ErrDispatcherStopped = errors.New("dispatcher stopped")
+	ErrDispatchQueueFull = errors.New("dispatch queue full")
+)
+
+type (
+	DispatchOption struct {
+		Workers   int // worker count, all messages of one client go to the same worker
+		QueueSize int // buffered tasks per worker
+	}
+
+	DispatchStats struct {
+		Workers   int
+		Queued    int64         // tasks waiting in worker queues
+		Processed int64         // tasks finished
+		LastLag   time.Duration // time the last task waited in its queue
+		MaxLag    time.Duration // longest time a task waited in its queue
+	}
+
+	// Dispatcher fans messages out to a fixed pool of workers, sharded by key,
+	// so tasks with the same key run in order while different keys run in parallel.
+	Dispatcher struct {
+		mu        sync.RWMutex
+		stopped   bool
+		wg        sync.WaitGroup
+		queues    []chan dispatchTask
+		queued    int64
+		processed int64
+		lastLag   int64
+		maxLag    int64
+	}
+
+	dispatchTask struct {
+		fn       func()
+		enqueued time.Time
+	}
+)
+
+func NewDispatcher(option *DispatchOption) *Dispatcher {
+	workers := defaultDispatchWorkers
+	queueSize := defaultDispatchQueueSize
+	if option != nil {
+		if option.Workers > 0 {
+			workers = option.Workers
+		}
+		if option.QueueSize > 0 {
+			queueSize = option.QueueSize
+		}
+	}
+
+	d := &Dispatcher{
+		queues: make([]chan dispatchTask, workers),
+	}
+	for i := range d.queues {
+		queue := make(chan dispatchTask, queueSize)
+		d.queues[i] = queue
+		d.wg.Add(1)
+		GoSafe(func() {
+			defer d.wg.Done()
+			d.work(queue)
+		})
+	}
+	return d
+}
+
+// Dispatch queues fn on the worker owning key, it blocks when that worker queue is full.
+func (d *Dispatcher) Dispatch(key string, fn func()) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.stopped {
+		return ErrDispatcherStopped
+	}
+	atomic.AddInt64(&d.queued, 1)
+	d.queues[d.shard(key)] <- dispatchTask{fn: fn, enqueued: time.Now()}
+	return nil
+}
+
+// TryDispatch is Dispatch without blocking, it returns ErrDispatchQueueFull when the worker queue is full.
+func (d *Dispatcher) TryDispatch(key string, fn func()) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.stopped {
+		return ErrDispatcherStopped
+	}
+	select {
+	case d.queues[d.shard(key)] <- dispatchTask{fn: fn, enqueued: time.Now()}:
+		atomic.AddInt64(&d.queued, 1)
+		return nil
+	default:
+		return ErrDispatchQueueFull
+	}
+}
+
+// Stop rejects new tasks and waits for the queued ones to finish.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	if !d.stopped {
+		d.stopped = true
+		for _, queue := range d.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) Stats() DispatchStats {
+	return DispatchStats{
+		Workers:   len(d.queues),
+		Queued:    atomic.LoadInt64(&d.queued),
+		Processed: atomic.LoadInt64(&d.processed),
+		LastLag:   time.Duration(atomic.LoadInt64(&d.lastLag)),
+		MaxLag:    time.Duration(atomic.LoadInt64(&d.maxLag)),
+	}
+}
+
+func (d *Dispatcher) work(queue chan dispatchTask) {
+	for task := range queue {
+		atomic.AddInt64(&d.queued, -1)
+		d.observeLag(time.Since(task.enqueued))
+		RunSafe(task.fn)
+		atomic.AddInt64(&d.processed, 1)
+	}
+}
+
+func (d *Dispatcher) observeLag(lag time.Duration) {
+	atomic.StoreInt64(&d.lastLag, int64(lag))
+	for {
+		max := atomic.LoadInt64(&d.maxLag)
+		if int64(lag) <= max || atomic.CompareAndSwapInt64(&d.maxLag, max, int64(lag)) {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) shard(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
@@ -0,0 +1,112 @@
+package redissub
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatcherKeepsOrderPerKey(t *testing.T) {
+	d := NewDispatcher(&DispatchOption{Workers: 4, QueueSize: 8})
+	defer d.Stop()
+
+	const keys, tasks = 8, 200
+	var mu sync.Mutex
+	got := map[string][]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < tasks; i++ {
+		for k := 0; k < keys; k++ {
+			key := fmt.Sprintf("client-%d", k)
+			n := i
+			wg.Add(1)
+			if err := d.Dispatch(key, func() {
+				defer wg.Done()
+				mu.Lock()
+				got[key] = append(got[key], n)
+				mu.Unlock()
+			}); err != nil {
+				t.Fatalf("Dispatch: %v", err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for key, seen := range got {
+		if len(seen) != tasks {
+			t.Fatalf("%s ran %d tasks, want %d", key, len(seen), tasks)
+		}
+		for i, n := range seen {
+			if n != i {
+				t.Fatalf("%s ran task %d at position %d", key, n, i)
+			}
+		}
+	}
+	if stats := d.Stats(); stats.Processed != keys*tasks || stats.Queued != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestDispatcherStopDrainsAndRejects(t *testing.T) {
+	d := NewDispatcher(&DispatchOption{Workers: 2, QueueSize: 16})
+
+	var ran int64
+	for i := 0; i < 10; i++ {
+		if err := d.Dispatch("key", func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&ran, 1)
+		}); err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+	}
+	d.Stop()
+
+	if n := atomic.LoadInt64(&ran); n != 10 {
+		t.Fatalf("Stop returned after %d of 10 queued tasks", n)
+	}
+	if err := d.Dispatch("key", func() {}); err != ErrDispatcherStopped {
+		t.Fatalf("Dispatch after Stop = %v, want ErrDispatcherStopped", err)
+	}
+	d.Stop() // a second Stop is a no-op
+}
+
+func TestDispatcherRecoversPanics(t *testing.T) {
+	d := NewDispatcher(&DispatchOption{Workers: 1})
+	defer d.Stop()
+
+	done := make(chan struct{})
+	d.Dispatch("key", func() { panic("boom") })
+	d.Dispatch("key", func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker died after a panicking task")
+	}
+}
+
+func TestDispatcherTryDispatch(t *testing.T) {
+	d := NewDispatcher(&DispatchOption{Workers: 1, QueueSize: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.Dispatch("key", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	if err := d.TryDispatch("key", func() {}); err != nil {
+		t.Fatalf("TryDispatch with room = %v", err)
+	}
+	if err := d.TryDispatch("key", func() {}); err != ErrDispatchQueueFull {
+		t.Fatalf("TryDispatch on a full queue = %v, want ErrDispatchQueueFull", err)
+	}
+	close(release)
+	d.Stop()
+	if err := d.TryDispatch("key", func() {}); err != ErrDispatcherStopped {
+		t.Fatalf("TryDispatch after Stop = %v, want ErrDispatcherStopped", err)
+	}
+	if stats := d.Stats(); stats.Processed != 2 || stats.Queued != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
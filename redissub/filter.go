@@ -0,0 +1,454 @@
+package redissub
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"strconv"
+	"strings"
+)
+
+// Filter is a compiled subscription filter evaluated against every message before it is delivered.
+//
+// Expressions compare event fields (Id, EventName, Data, Time, Seq, ExpiresAt, Priority) or fields of the
+// json Data through the data prefix, e.g.
+//
+//	EventName in ["order.updated", "order.created"] && data.status == "shipped"
+//
+// Supported operators are ==, !=, <, <=, >, >=, in, &&, || and !, with parentheses for grouping.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+type (
+	filterNode interface {
+		eval(env *filterEnv) interface{}
+	}
+
+	literalNode struct {
+		value interface{}
+	}
+
+	pathNode struct {
+		path []string
+	}
+
+	listNode struct {
+		items []filterNode
+	}
+
+	notNode struct {
+		node filterNode
+	}
+
+	logicNode struct {
+		op          string
+		left, right filterNode
+	}
+
+	compareNode struct {
+		op          string
+		left, right filterNode
+	}
+
+	filterEnv struct {
+		event  *Event
+		data   interface{}
+		parsed bool
+	}
+
+	filterToken struct {
+		kind  int
+		text  string
+		value interface{}
+		pos   int
+	}
+
+	filterParser struct {
+		tokens []filterToken
+		pos    int
+	}
+)
+
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenPunct
+)
+
+// CompileFilter parses a filter expression.
+func CompileFilter(expr string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	parser := &filterParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := parser.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("filter: unexpected %q at %d", tok.text, tok.pos)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether event passes the filter.
+func (f *Filter) Match(event *Event) bool {
+	return truthy(f.root.eval(&filterEnv{event: event}))
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	i := 0
+	for i < len(expr) {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(' || ch == ')' || ch == '[' || ch == ']' || ch == ',':
+			tokens = append(tokens, filterToken{kind: tokenPunct, text: string(ch), pos: i})
+			i++
+		case ch == '"' || ch == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(expr) && expr[j] != ch {
+				if expr[j] == '\\' && j+1 < len(expr) {
+					j++
+				}
+				sb.WriteByte(expr[j])
+				j++
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("filter: unterminated string at %d", i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: expr[i : j+1], value: sb.String(), pos: i})
+			i = j + 1
+		case ch == '-' || (ch >= '0' && ch <= '9'):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '.' || (expr[j] >= '0' && expr[j] <= '9')) {
+				j++
+			}
+			number, err := strconv.ParseFloat(expr[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("filter: bad number %q at %d", expr[i:j], i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: expr[i:j], value: number, pos: i})
+			i = j
+		case isIdentByte(ch):
+			j := i + 1
+			for j < len(expr) && (isIdentByte(expr[j]) || expr[j] == '.' || (expr[j] >= '0' && expr[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: expr[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("filter: unexpected %q at %d", ch, i)
+			}
+			tokens = append(tokens, filterToken{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, text: "end of expression", pos: len(expr)}), nil
+}
+
+func isIdentByte(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) expect(text string) error {
+	if tok := p.next(); tok.text != text {
+		return fmt.Errorf("filter: expected %q at %d, got %q", text, tok.pos, tok.text)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOp && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if tok := p.peek(); tok.kind == tokenOp && tok.text == "!" {
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: node}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	if tok := p.peek(); tok.kind == tokenPunct && tok.text == "(" {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	isCompare := tok.kind == tokenOp && tok.text != "&&" && tok.text != "||" && tok.text != "!"
+	if !isCompare && !(tok.kind == tokenIdent && tok.text == "in") {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{op: tok.text, left: left, right: right}, nil
+}
+
+func (p *filterParser) parseOperand() (filterNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString, tokenNumber:
+		return &literalNode{value: tok.value}, nil
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		return &pathNode{path: strings.Split(tok.text, ".")}, nil
+	case tokenPunct:
+		if tok.text == "[" {
+			return p.parseList()
+		}
+	}
+	return nil, fmt.Errorf("filter: unexpected %q at %d", tok.text, tok.pos)
+}
+
+func (p *filterParser) parseList() (filterNode, error) {
+	list := &listNode{items: []filterNode{}}
+	if tok := p.peek(); tok.kind == tokenPunct && tok.text == "]" {
+		p.next()
+		return list, nil
+	}
+	for {
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		list.items = append(list.items, item)
+		tok := p.next()
+		if tok.text == "]" {
+			return list, nil
+		}
+		if tok.text != "," {
+			return nil, fmt.Errorf("filter: expected \",\" or \"]\" at %d, got %q", tok.pos, tok.text)
+		}
+	}
+}
+
+func (n *literalNode) eval(env *filterEnv) interface{} {
+	return n.value
+}
+
+func (n *pathNode) eval(env *filterEnv) interface{} {
+	event := env.event
+	switch n.path[0] {
+	case "Id":
+		return event.Id
+	case "EventName":
+		return event.EventName
+	case "Time":
+		return float64(event.Time)
+	case "Seq":
+		return float64(event.Seq)
+	case "ExpiresAt":
+		return float64(event.ExpiresAt)
+	case "Priority":
+		return float64(event.Priority)
+	case "Data":
+		if len(n.path) == 1 {
+			return event.Data
+		}
+	case "data":
+	default:
+		return nil
+	}
+
+	var value interface{} = env.parsedData()
+	for _, key := range n.path[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func (n *listNode) eval(env *filterEnv) interface{} {
+	values := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		values = append(values, item.eval(env))
+	}
+	return values
+}
+
+func (n *notNode) eval(env *filterEnv) interface{} {
+	return !truthy(n.node.eval(env))
+}
+
+func (n *logicNode) eval(env *filterEnv) interface{} {
+	if n.op == "&&" {
+		return truthy(n.left.eval(env)) && truthy(n.right.eval(env))
+	}
+	return truthy(n.left.eval(env)) || truthy(n.right.eval(env))
+}
+
+func (n *compareNode) eval(env *filterEnv) interface{} {
+	left := n.left.eval(env)
+	right := n.right.eval(env)
+	switch n.op {
+	case "==":
+		return equalValue(left, right)
+	case "!=":
+		return !equalValue(left, right)
+	case "in":
+		values, ok := right.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range values {
+			if equalValue(left, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if a, ok := left.(float64); ok {
+		if b, ok := right.(float64); ok {
+			return compareOrder(n.op, a < b, a == b)
+		}
+	}
+	if a, ok := left.(string); ok {
+		if b, ok := right.(string); ok {
+			return compareOrder(n.op, a < b, a == b)
+		}
+	}
+	return false
+}
+
+func (env *filterEnv) parsedData() interface{} {
+	if !env.parsed {
+		env.parsed = true
+		_ = jsoniter.Unmarshal([]byte(env.event.Data), &env.data)
+	}
+	return env.data
+}
+
+func compareOrder(op string, less, equal bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	}
+	return false
+}
+
+func equalValue(a, b interface{}) bool {
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	case float64:
+		bv, ok := b.(float64)
+		return ok && av == bv
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	}
+	return false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
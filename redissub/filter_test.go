@@ -0,0 +1,144 @@
+package redissub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizeFilter(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want []string
+	}{
+		{`a == 1`, []string{"a", "==", "1"}},
+		{`data.user.id>=-2.5`, []string{"data.user.id", ">=", "-2.5"}},
+		{`x in ["a", 'b']`, []string{"x", "in", "[", `"a"`, ",", `'b'`, "]"}},
+		{`!(a||b)&&c`, []string{"!", "(", "a", "||", "b", ")", "&&", "c"}},
+		{`s == "q\"uote"`, []string{"s", "==", `"q\"uote"`}},
+	} {
+		tokens, err := tokenizeFilter(tc.expr)
+		if err != nil {
+			t.Fatalf("tokenizeFilter(%q): %v", tc.expr, err)
+		}
+		got := []string{}
+		for _, tok := range tokens {
+			if tok.kind != tokenEOF {
+				got = append(got, tok.text)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("tokenizeFilter(%q) = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestTokenizeFilterValues(t *testing.T) {
+	tokens, err := tokenizeFilter(`"a\"b" -3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens[0].value != `a"b` {
+		t.Errorf("string value = %q", tokens[0].value)
+	}
+	if tokens[1].value != -3.0 {
+		t.Errorf("number value = %v", tokens[1].value)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	event := &Event{
+		Id:        "m1",
+		EventName: "order.updated",
+		Data:      `{"status":"shipped","total":42,"vip":true,"user":{"id":"u7","tags":["a"]}}`,
+		Time:      1000,
+		Seq:       5,
+		Priority:  PriorityHigh,
+	}
+	for _, tc := range []struct {
+		expr string
+		want bool
+	}{
+		// event fields
+		{`EventName == "order.updated"`, true},
+		{`Id != "m1"`, false},
+		{`Time > 999 && Time <= 1000`, true},
+		{`Seq >= 5`, true},
+		{`Priority == 2`, true},
+		{`ExpiresAt == 0`, true},
+		{`Data == "x"`, false},
+		{`Unknown == null`, true},
+
+		// in
+		{`EventName in ["order.created", "order.updated"]`, true},
+		{`EventName in []`, false},
+		{`data.total in [1, 42]`, true},
+		{`data.total in 42`, false},
+
+		// data paths
+		{`data.status == "shipped"`, true},
+		{`data.user.id == "u7"`, true},
+		{`data.user.missing == null`, true},
+		{`data.status.deeper == null`, true},
+		{`data.vip`, true},
+		{`data.vip == true`, true},
+		{`data.total > 40 && data.total < 50`, true},
+		{`data.status < "t"`, true},
+		{`data.total == "42"`, false},
+
+		// precedence: && binds tighter than ||, ! binds tightest
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`!data.vip || data.total == 42`, true},
+		{`false || false || true`, true},
+		{`true && true && false`, false},
+	} {
+		f, err := CompileFilter(tc.expr)
+		if err != nil {
+			t.Fatalf("CompileFilter(%q): %v", tc.expr, err)
+		}
+		if got := f.Match(event); got != tc.want {
+			t.Errorf("%q = %v, want %v", tc.expr, got, tc.want)
+		}
+		if f.String() != tc.expr {
+			t.Errorf("String() = %q, want %q", f.String(), tc.expr)
+		}
+	}
+}
+
+func TestFilterMatchBadData(t *testing.T) {
+	f, err := CompileFilter(`data.status == "shipped"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Match(&Event{Data: "not json"}) {
+		t.Fatal("matched an event with non json data")
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{`a == "open`, `unterminated string at 5`},
+		{`a # 1`, `unexpected '#' at 2`},
+		{`a == 1.2.3`, `bad number "1.2.3" at 5`},
+		{`a ==`, `unexpected "end of expression" at 4`},
+		{`(a == 1`, `expected ")" at 7`},
+		{`a == 1 b`, `unexpected "b" at 7`},
+		{`a in [1 2]`, `expected "," or "]" at 8`},
+		{`== 1`, `unexpected "==" at 0`},
+		{`a == )`, `unexpected ")" at 5`},
+	} {
+		_, err := CompileFilter(tc.expr)
+		if err == nil {
+			t.Errorf("CompileFilter(%q) succeeded", tc.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("CompileFilter(%q) = %v, want %q", tc.expr, err, tc.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"time"
 )
 
 type (
@@ -17,10 +18,15 @@ type (
 	GenUUIDFun     func(r *http.Request) string
 
 	Event struct {
-		Id        string `json:"Id"`
-		EventName string `json:"EventName"`
-		Data      string `json:"Data"`
-		Time      int64  `json:"Time"`
+		Id        string   `json:"Id"`
+		EventName string   `json:"EventName"`
+		Data      string   `json:"Data"`
+		Time      int64    `json:"Time"`
+		Seq       int64    `json:"Seq,omitempty"`       // per channel, assigned by Publish, consecutive except when a counter restarts higher
+		ExpiresAt int64    `json:"ExpiresAt,omitempty"` // unix milliseconds, 0 never expires
+		Priority  Priority `json:"Priority,omitempty"`
+		Filter    string   `json:"Filter,omitempty"`  // subscribe events only, see CompileFilter
+		Channel   string   `json:"Channel,omitempty"` // stamped by Publish
 	}
 )
 
@@ -38,6 +44,11 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// Expired reports whether the event passed its ExpiresAt.
+func (e *Event) Expired(now time.Time) bool {
+	return e.ExpiresAt > 0 && now.UnixMilli() >= e.ExpiresAt
+}
+
 func AddWsEvent(eventName string, channelFun ChannelFun, onMessage OnMessage) {
 	if _, ok := subScribeFuncs[eventName]; !ok {
 		subScribeFuncs[eventName] = OnMessageWrapper{
@@ -49,6 +60,10 @@ func AddWsEvent(eventName string, channelFun ChannelFun, onMessage OnMessage) {
 
 // ServeWs handles websocket requests from the peer.
 func ServeWs(pubSubClient *PubSubClient, w http.ResponseWriter, r *http.Request, genUUIDFun GenUUIDFun) {
+	if pubSubClient.isClosing() {
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
@@ -57,14 +72,27 @@ func ServeWs(pubSubClient *PubSubClient, w http.ResponseWriter, r *http.Request,
 	id := genUUIDFun(r)
 	ctx := r.Context()
 	client := MustNewClient(ctx, conn, id, pubSubClient.SolidOption)
-
+	client.Backpressure = pubSubClient.BackpressureOption
+	client.Subscribe(BroadcastChannel) // ack and resend of persisted broadcasts, delivered through the control channel
+	if pubSubClient.OnConnect != nil {
+		pubSubClient.OnConnect(r, client)
+	}
+	if !pubSubClient.addClient(client) {
+		// Shutdown started during the upgrade
+		client.Close(websocket.CloseServiceRestart, "server restarting")
+		return
+	}
 	GoSafe(func() {
+		client.join(pubSubClient, GenInboxChannel(id), deliverOnMessage, nil)
+	})
+
+	pubSubClient.goSafe(func() {
 		client.ReadPump(pubSubClient)
 	})
-	GoSafe(func() {
+	pubSubClient.goSafe(func() {
 		client.writePump(pubSubClient)
 	})
-	GoSafe(func() {
+	pubSubClient.goSafe(func() {
 		client.Solid.MonitorReSend()
 	})
 }
@@ -0,0 +1,112 @@
+package redissub
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// newTestServer serves p over websocket, the client id is the id query parameter.
+func newTestServer(t *testing.T, p *PubSubClient) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWs(p, w, r, func(r *http.Request) string {
+			return r.URL.Query().Get("id")
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialTest(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestConn returns a client on the server side of a websocket connection without its pumps, and the peer.
+func newTestConn(t *testing.T, id string, solidOption *SolidOption) (*Client, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return MustNewClient(context.Background(), <-conns, id, solidOption), peer
+}
+
+// readEvent reads frames from conn until one satisfies match, a websocket message may hold several frames.
+func readEvent(t *testing.T, conn *websocket.Conn, match func(*Event) bool) *Event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		for _, frame := range bytes.Split(message, newline) {
+			var event Event
+			if jsoniter.Unmarshal(frame, &event) == nil && match(&event) {
+				return &event
+			}
+		}
+	}
+}
+
+// expectNoEvent fails when conn receives a frame satisfying match within wait.
+func expectNoEvent(t *testing.T, conn *websocket.Conn, wait time.Duration, match func(*Event) bool) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(wait))
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return // deadline, the connection can not be read again
+		}
+		for _, frame := range bytes.Split(message, newline) {
+			var event Event
+			if jsoniter.Unmarshal(frame, &event) == nil && match(&event) {
+				t.Fatalf("unexpected frame %s", frame)
+			}
+		}
+	}
+}
+
+// readClose reads until conn is closed and returns the close code.
+func readClose(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			closeErr, ok := err.(*websocket.CloseError)
+			if !ok {
+				t.Fatalf("read: %v, want a close frame", err)
+			}
+			return closeErr.Code
+		}
+	}
+}
+
+func eventNamed(name string) func(*Event) bool {
+	return func(event *Event) bool { return event.EventName == name }
+}
@@ -0,0 +1,171 @@
+package redissub
+
+import (
+	"context"
+	red "github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+
+	// historyScanLimit bounds the entries one time bounded page examines, Next continues after them
+	historyScanLimit = 10 * maxHistoryLimit
+)
+
+type (
+	// HistoryQuery selects offline log entries by score, which is the event Seq.
+	// Since and Until were event Time bounds before the log was scored by sequence number,
+	// time ranges are selected with SinceTime and UntilTime now.
+	HistoryQuery struct {
+		Since   int64 // inclusive lower Seq bound, 0 from the oldest
+		Until   int64 // inclusive upper Seq bound, 0 up to the newest
+		Before  int64 // exclusive upper Seq bound, the cursor when scrolling back
+		Limit   int64 // default 50, at most 1000
+		Reverse bool  // newest first
+
+		// SinceTime and UntilTime are inclusive event Time bounds in unix milliseconds, 0 unbounded.
+		// Time is not ordered like Seq, so a time bounded page may hold fewer than Limit events, keep paging with Next.
+		SinceTime int64
+		UntilTime int64
+	}
+
+	HistoryPage struct {
+		Events []*Event `json:"Events"`
+		// Next continues the query, as Before when Reverse and as Since otherwise, 0 when there is nothing more
+		Next int64 `json:"Next"`
+	}
+)
+
+// History pages through the offline log of channel.
+func (p *PubSubClient) History(ctx context.Context, channel string, query HistoryQuery) (*HistoryPage, error) {
+	offline := &OffLine{
+		ExpireTime: p.SolidOption.ExpireTime,
+		Rdb:        p.Publisher,
+		Key:        GenOfflineKey(channel),
+	}
+	return offline.History(ctx, query)
+}
+
+func (o *OffLine) History(ctx context.Context, query HistoryQuery) (*HistoryPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	min, max := "-inf", "+inf"
+	if query.Since > 0 {
+		min = strconv.FormatInt(query.Since, 10)
+	}
+	if query.Until > 0 {
+		max = strconv.FormatInt(query.Until, 10)
+	}
+	if query.Before > 0 && (query.Until <= 0 || query.Before <= query.Until) {
+		max = "(" + strconv.FormatInt(query.Before, 10)
+	}
+
+	page := &HistoryPage{Events: []*Event{}}
+	next := func(last int64) int64 {
+		if query.Reverse {
+			return last
+		}
+		return last + 1
+	}
+	for scanned := int64(0); ; {
+		by := &red.ZRangeBy{Min: min, Max: max, Offset: 0, Count: limit}
+		var cmd *red.ZSliceCmd
+		if query.Reverse {
+			cmd = o.Rdb.ZRevRangeByScoreWithScores(ctx, o.Key, by)
+		} else {
+			cmd = o.Rdb.ZRangeByScoreWithScores(ctx, o.Key, by)
+		}
+		result, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range result {
+			last := int64(item.Score)
+			member, ok := item.Member.(string)
+			if !ok {
+				continue
+			}
+			var event Event
+			if err := jsoniter.Unmarshal([]byte(member), &event); err != nil {
+				continue
+			}
+			if (query.SinceTime > 0 && event.Time < query.SinceTime) || (query.UntilTime > 0 && event.Time > query.UntilTime) {
+				continue
+			}
+			page.Events = append(page.Events, &event)
+			if int64(len(page.Events)) == limit {
+				page.Next = next(last)
+				return page, nil
+			}
+		}
+		if int64(len(result)) < limit {
+			return page, nil
+		}
+
+		// entries were skipped, continue after the last examined one
+		last := int64(result[len(result)-1].Score)
+		if scanned += int64(len(result)); scanned >= historyScanLimit {
+			page.Next = next(last)
+			return page, nil
+		}
+		if query.Reverse {
+			max = "(" + strconv.FormatInt(last, 10)
+		} else {
+			min = "(" + strconv.FormatInt(last, 10)
+		}
+	}
+}
+
+// HistoryHandler serves History as json, reading channel, since, until, before, since_time, until_time,
+// limit and reverse from the query string. Wrap it with your own authorization, it does not check channel access.
+func HistoryHandler(pubSubClient *PubSubClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		channel := values.Get("channel")
+		if channel == "" {
+			http.Error(w, "channel required", http.StatusBadRequest)
+			return
+		}
+
+		var query HistoryQuery
+		for name, field := range map[string]*int64{
+			"since":      &query.Since,
+			"until":      &query.Until,
+			"before":     &query.Before,
+			"since_time": &query.SinceTime,
+			"until_time": &query.UntilTime,
+			"limit":      &query.Limit,
+		} {
+			value := values.Get(name)
+			if value == "" {
+				continue
+			}
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				http.Error(w, "bad "+name, http.StatusBadRequest)
+				return
+			}
+			*field = number
+		}
+		query.Reverse, _ = strconv.ParseBool(values.Get("reverse"))
+
+		page, err := pubSubClient.History(r.Context(), channel, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		jsoniter.NewEncoder(w).Encode(page)
+	}
+}
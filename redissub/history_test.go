@@ -0,0 +1,113 @@
+package redissub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSequenceNumbers(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	seqs := []int64{}
+	for i := 0; i < 3; i++ {
+		event := &Event{Data: fmt.Sprint(i)}
+		if _, err := p.Publish(ctx, "room", event); err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, event.Seq)
+	}
+	if seqs[1] != seqs[0]+1 || seqs[2] != seqs[1]+1 {
+		t.Fatalf("sequence numbers %v are not consecutive", seqs)
+	}
+	if ttl := m.TTL(GenSeqKey("room")); ttl != time.Hour {
+		t.Fatalf("sequence key ttl = %v, want the offline ttl", ttl)
+	}
+	if !m.Exists("redissub:offline:seq:zset:room") || m.Exists("redissub:offline:zset:room") {
+		t.Fatal("offline log is not under the sequence scored key")
+	}
+
+	// a lost counter never restarts below sequences already handed out
+	m.Del(GenSeqKey("room"))
+	event := &Event{Data: "after loss"}
+	p.Publish(ctx, "room", event)
+	if event.Seq <= seqs[2] {
+		t.Fatalf("sequence after a lost counter = %d, not above %d", event.Seq, seqs[2])
+	}
+
+	// also when the offline log is gone too
+	m.Del(GenSeqKey("room"))
+	m.Del(GenOfflineKey("room"))
+	again := &Event{Data: "after both"}
+	p.Publish(ctx, "room", again)
+	if again.Seq <= event.Seq {
+		t.Fatalf("sequence after a lost counter and log = %d, not above %d", again.Seq, event.Seq)
+	}
+}
+
+func TestHistoryPaging(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	seqs := []int64{}
+	for i := 0; i < 5; i++ {
+		event := &Event{Data: fmt.Sprint(i)}
+		p.Publish(ctx, "room", event)
+		seqs = append(seqs, event.Seq)
+	}
+
+	page, err := p.History(ctx, "room", HistoryQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 || page.Events[0].Seq != seqs[0] || page.Next != seqs[1]+1 {
+		t.Fatalf("first page = %v events, next %d", len(page.Events), page.Next)
+	}
+	page, _ = p.History(ctx, "room", HistoryQuery{Since: page.Next, Limit: 10})
+	if len(page.Events) != 3 || page.Events[0].Seq != seqs[2] || page.Next != 0 {
+		t.Fatalf("second page = %v events, next %d", len(page.Events), page.Next)
+	}
+
+	page, _ = p.History(ctx, "room", HistoryQuery{Reverse: true, Limit: 2})
+	if len(page.Events) != 2 || page.Events[0].Seq != seqs[4] || page.Next != seqs[3] {
+		t.Fatalf("reverse page = %v events, next %d", len(page.Events), page.Next)
+	}
+	page, _ = p.History(ctx, "room", HistoryQuery{Reverse: true, Before: page.Next, Limit: 10})
+	if len(page.Events) != 3 || page.Events[0].Seq != seqs[2] {
+		t.Fatalf("reverse second page = %v events", len(page.Events))
+	}
+}
+
+func TestHistoryTimeBounds(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	// Time is set by the caller and not ordered like Seq
+	for _, at := range []int64{5000, 1000, 3000, 2000, 4000, 3500} {
+		p.Publish(ctx, "room", &Event{Data: fmt.Sprint(at), Time: at})
+	}
+
+	got := []string{}
+	query := HistoryQuery{SinceTime: 2000, UntilTime: 3500, Limit: 2}
+	for {
+		page, err := p.History(ctx, "room", query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range page.Events {
+			got = append(got, event.Data)
+		}
+		if page.Next == 0 {
+			break
+		}
+		query.Since = page.Next
+	}
+	if fmt.Sprint(got) != "[3000 2000 3500]" {
+		t.Fatalf("time bounded history = %v", got)
+	}
+}
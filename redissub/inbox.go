@@ -0,0 +1,25 @@
+package redissub
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	inboxPrefix = "redissub:inbox:%v"
+)
+
+// SendToClient publishes event to the inbox of one client, wherever it is connected.
+// The inbox is a normal channel with offline log, waiter and offset, so the message survives
+// reconnects as long as the client reconnects with the same id.
+func (p *PubSubClient) SendToClient(ctx context.Context, clientId string, event *Event) (MessageID, error) {
+	return p.Publish(ctx, GenInboxChannel(clientId), event)
+}
+
+func GenInboxChannel(clientId string) string {
+	return fmt.Sprintf(inboxPrefix, clientId)
+}
+
+func deliverOnMessage(client *Client, data []byte) {
+	client.Deliver(data)
+}
@@ -0,0 +1,41 @@
+package redissub
+
+import (
+	"context"
+	red "github.com/go-redis/redis/v8"
+	"time"
+)
+
+// leaderScript takes the lock when it is free and renews it when we already own it.
+// KEYS[1] lock key, ARGV[1] owner id, ARGV[2] ttl in milliseconds
+var leaderScript = red.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner == false then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+if owner == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+type (
+	// Leader is a redis lock electing one node to run a cluster wide background job.
+	Leader struct {
+		Key string
+		Id  string
+		Rdb *red.Client
+		Ttl time.Duration // lock ttl, renewed by every successful Acquire
+	}
+)
+
+// Acquire reports whether this node holds the lock, taking or renewing it.
+func (l *Leader) Acquire(ctx context.Context) bool {
+	ok, err := leaderScript.Run(ctx, l.Rdb, []string{l.Key}, l.Id, l.Ttl.Milliseconds()).Int()
+	if err != nil {
+		return false
+	}
+	return ok == 1
+}
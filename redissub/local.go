@@ -0,0 +1,100 @@
+package redissub
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// how long a locally delivered id waits for its redis echo
+	localEchoTtl        = time.Minute
+	localEchoPurgeEvery = 1024
+)
+
+type (
+	// localEcho remembers ids delivered locally by Publish so Run drops their redis echo.
+	// Markers are counted, a duplicate publish of an id adds and removes its own marker
+	// without touching the one of a first publish still waiting for its echo.
+	localEcho struct {
+		mu    sync.Mutex
+		ids   map[string]*echoMarker
+		added int
+	}
+
+	echoMarker struct {
+		count  int
+		expire time.Time
+	}
+)
+
+func newLocalEcho() *localEcho {
+	return &localEcho{ids: map[string]*echoMarker{}}
+}
+
+func (l *localEcho) add(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	marker, ok := l.ids[id]
+	if !ok {
+		marker = &echoMarker{}
+		l.ids[id] = marker
+	}
+	marker.count++
+	marker.expire = now.Add(localEchoTtl)
+	l.added++
+	if l.added%localEchoPurgeEvery == 0 {
+		for key, marker := range l.ids {
+			if now.After(marker.expire) {
+				delete(l.ids, key)
+			}
+		}
+	}
+}
+
+// take reports whether id was delivered locally, forgetting one marker.
+func (l *localEcho) take(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	marker, ok := l.ids[id]
+	if !ok {
+		return false
+	}
+	if marker.count--; marker.count <= 0 {
+		delete(l.ids, id)
+	}
+	return true
+}
+
+// expectEcho is called before publishing, the redis echo may reach Run before Publish returns.
+func (p *PubSubClient) expectEcho(call *publishCall) {
+	if p.localEcho != nil {
+		p.localEcho.add(call.event.Id)
+	}
+}
+
+func (p *PubSubClient) forgetEcho(call *publishCall) {
+	if p.localEcho != nil {
+		p.localEcho.take(call.event.Id)
+	}
+}
+
+// deliverLocal hands a just published message to this node listeners without waiting for redis.
+func (p *PubSubClient) deliverLocal(call *publishCall) {
+	if p.localEcho != nil {
+		p.dispatchWith(call.channel, call.data, p.enqueueLocal)
+	}
+}
+
+// enqueueLocal never blocks the publisher, which may be a handler on the worker of the full queue itself.
+// A task that does not fit is queued from its own goroutine, behind what the worker has to drain first.
+func (p *PubSubClient) enqueueLocal(key string, fn func()) error {
+	err := p.Dispatcher.TryDispatch(key, fn)
+	if err == ErrDispatchQueueFull {
+		GoSafe(func() {
+			p.Dispatcher.Dispatch(key, fn)
+		})
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package redissub
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocalEchoCountsMarkers(t *testing.T) {
+	l := newLocalEcho()
+	l.add("m1")        // first publish, echo pending
+	l.add("m1")        // duplicate publish
+	if !l.take("m1") { // duplicate forgets its own marker
+		t.Fatal("take of a duplicate marker failed")
+	}
+	if !l.take("m1") {
+		t.Fatal("the echo of the first publish is no longer expected")
+	}
+	if l.take("m1") {
+		t.Fatal("take succeeded without a marker")
+	}
+}
+
+func TestLocalDeliveryDuplicatePublish(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := NewPubSubClient(PubSubRedisOptions{
+		Publisher:     rdb,
+		Subscriber:    rdb,
+		SolidOption:   &SolidOption{ExpireTime: time.Hour, Rdb: rdb},
+		LocalDelivery: true,
+	})
+	defer p.Shutdown(context.Background())
+	ctx := context.Background()
+
+	var delivered int64
+	p.SubscribeFunc("orders", func(ctx context.Context, event *Event) error {
+		atomic.AddInt64(&delivered, 1)
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	first, err := p.Publish(ctx, "orders", &Event{Id: "order-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.Publish(ctx, "orders", &Event{Id: "order-1"})
+	if err != nil || second != first {
+		t.Fatalf("duplicate publish = %v, %v, want %v", second, err, first)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&delivered); n != 1 {
+		t.Fatalf("delivered %d times, want 1", n)
+	}
+}
+
+func TestLocalDeliveryFromHandlerOnFullQueue(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := NewPubSubClient(PubSubRedisOptions{
+		Publisher:      rdb,
+		Subscriber:     rdb,
+		SolidOption:    &SolidOption{ExpireTime: time.Hour, Rdb: rdb},
+		LocalDelivery:  true,
+		DispatchOption: &DispatchOption{Workers: 1, QueueSize: 1},
+	})
+	defer p.Shutdown(context.Background())
+
+	// the handler runs on the only worker and publishes more than its queue holds to its own channel
+	const fanout = 5
+	var delivered int64
+	p.SubscribeFunc("loop", func(ctx context.Context, event *Event) error {
+		atomic.AddInt64(&delivered, 1)
+		if event.Data == "start" {
+			for i := 0; i < fanout; i++ {
+				if _, err := p.Publish(ctx, "loop", &Event{Data: "next"}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := p.Publish(context.Background(), "loop", &Event{Data: "start"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&delivered) < fanout+1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("delivered %d of %d, the worker blocked on its own queue", atomic.LoadInt64(&delivered), fanout+1)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&delivered); n != fanout+1 {
+		t.Fatalf("delivered %d, want %d", n, fanout+1)
+	}
+}
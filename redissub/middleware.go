@@ -0,0 +1,47 @@
+package redissub
+
+type (
+	// Handler handles one inbound frame read from a client.
+	Handler func(client *Client, event *Event)
+	// Middleware wraps an inbound Handler, returning without calling next short-circuits the frame.
+	Middleware func(next Handler) Handler
+
+	// DeliveryHandler delivers one channel message to a subscribed client.
+	DeliveryHandler func(client *Client, channel string, data []byte)
+	// DeliveryMiddleware wraps a DeliveryHandler, returning without calling next drops the delivery.
+	DeliveryMiddleware func(next DeliveryHandler) DeliveryHandler
+)
+
+// UseInbound appends middlewares for inbound frames, the first added runs first.
+func (p *PubSubClient) UseInbound(middlewares ...Middleware) {
+	p.mwMu.Lock()
+	defer p.mwMu.Unlock()
+	p.inbound = append(p.inbound, middlewares...)
+}
+
+// UseOutbound appends middlewares for outbound deliveries, the first added runs first.
+func (p *PubSubClient) UseOutbound(middlewares ...DeliveryMiddleware) {
+	p.mwMu.Lock()
+	defer p.mwMu.Unlock()
+	p.outbound = append(p.outbound, middlewares...)
+}
+
+func (p *PubSubClient) inboundChain(final Handler) Handler {
+	p.mwMu.RLock()
+	defer p.mwMu.RUnlock()
+	handler := final
+	for i := len(p.inbound) - 1; i >= 0; i-- {
+		handler = p.inbound[i](handler)
+	}
+	return handler
+}
+
+func (p *PubSubClient) outboundChain(final DeliveryHandler) DeliveryHandler {
+	p.mwMu.RLock()
+	defer p.mwMu.RUnlock()
+	handler := final
+	for i := len(p.outbound) - 1; i >= 0; i-- {
+		handler = p.outbound[i](handler)
+	}
+	return handler
+}
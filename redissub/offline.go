@@ -10,7 +10,9 @@ import (
 )
 
 const (
-	offlinePrefix = "redissub:offline:zset:%v"
+	// offline logs are scored by sequence number, the time scored logs of earlier versions lived under
+	// redissub:offline:zset:<channel> and are left to expire
+	offlinePrefix = "redissub:offline:seq:zset:%v"
 )
 
 type (
@@ -21,25 +23,11 @@ type (
 	}
 )
 
-func (o *OffLine) AddToOffline(ctx context.Context, data []byte) {
-	var event Event
-	err := jsoniter.Unmarshal(data, &event)
-
-	if err == nil {
-		o.Rdb.ZAdd(ctx, o.Key, &red.Z{
-			Score:  float64(event.Time),
-			Member: string(data),
-		})
-		o.Rdb.Expire(ctx, o.Key, o.ExpireTime)
-	}
-
-}
-
+// MessageByOffset returns the messages with a sequence number after offset.
 func (o *OffLine) MessageByOffset(ctx context.Context, offset int64) ([]string, error) {
-	max := time.Now().UnixMilli()
 	result, err := o.Rdb.ZRangeByScore(ctx, o.Key, &red.ZRangeBy{
-		Min:    strconv.Itoa(int(offset)),
-		Max:    strconv.Itoa(int(max)),
+		Min:    "(" + strconv.FormatInt(offset, 10),
+		Max:    "+inf",
 		Offset: 0,
 		Count:  1<<63 - 1,
 	}).Result()
@@ -50,15 +38,21 @@ func (o *OffLine) MessageByOffset(ctx context.Context, offset int64) ([]string,
 	return result, nil
 }
 
-func (o *OffLine) PullOffLine(ctx context.Context, online *Online) {
+// PullOffLine pushes the messages after the online offset to the waiter, skipping received,
+// expired and, with a filter, not matching ones.
+func (o *OffLine) PullOffLine(ctx context.Context, online *Online, filter *Filter) {
 	offset := online.Offset.Offset(ctx)
 	datas, err := o.MessageByOffset(ctx, offset)
 	if err != nil {
 		return
 	}
 	if datas != nil && len(datas) > 0 {
+		now := time.Now()
 		for _, item := range datas {
 			event := []byte(item)
+			if !deliverable(event, now, filter) {
+				continue
+			}
 			if !online.Receiver.IsReceived(ctx, event) {
 				online.Waiter.Push(ctx, event)
 			}
@@ -66,6 +60,23 @@ func (o *OffLine) PullOffLine(ctx context.Context, online *Online) {
 	}
 }
 
+func isExpired(data []byte, now time.Time) bool {
+	var event Event
+	if err := jsoniter.Unmarshal(data, &event); err != nil {
+		return false
+	}
+	return event.Expired(now)
+}
+
+// deliverable reports whether data is not expired and matches filter, a nil filter matches everything.
+func deliverable(data []byte, now time.Time, filter *Filter) bool {
+	var event Event
+	if err := jsoniter.Unmarshal(data, &event); err != nil {
+		return true
+	}
+	return !event.Expired(now) && (filter == nil || filter.Match(&event))
+}
+
 func GenOfflineKey(channel string) string {
 	return fmt.Sprintf(offlinePrefix, channel)
 }
@@ -0,0 +1,48 @@
+package redissub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPullOfflineMessageAppliesFilter(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	shipped, _ := p.Publish(ctx, "orders", &Event{Id: "shipped", Data: `{"status":"shipped"}`})
+	p.Publish(ctx, "orders", &Event{Id: "pending", Data: `{"status":"pending"}`})
+	p.Publish(ctx, "orders", &Event{Id: "expired", Data: `{"status":"shipped"}`, ExpiresAt: time.Now().Add(-time.Minute).UnixMilli()})
+
+	filter, err := CompileFilter(`data.status == "shipped"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestSubscriber(p, "u1", "orders")
+	c.filters["orders"] = filter
+	c.Solid.PullOfflineMessage()
+
+	got := waiterIds(t, rdb, "orders", "u1")
+	if len(got) != 1 || !got[string(shipped)] {
+		t.Fatalf("waiter = %v, want only %v", got, shipped)
+	}
+}
+
+func TestPullOfflineMessageSkipsReceived(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	p.Publish(ctx, "orders", &Event{Id: "a"})
+	p.Publish(ctx, "orders", &Event{Id: "b"})
+
+	c := newTestSubscriber(p, "u1", "orders")
+	c.Solid.online("orders").Receiver.Received(ctx, &Event{Id: "a"})
+	c.Solid.PullOfflineMessage()
+
+	got := waiterIds(t, rdb, "orders", "u1")
+	if len(got) != 1 || !got["b"] {
+		t.Fatalf("waiter = %v, want only b", got)
+	}
+}
@@ -12,14 +12,17 @@ import (
 const (
 	waiterPrefix   = "redissub:online:waiter:hash:%v:%v"
 	receiverPrefix = "redissub:online:receiver:hash:%v:%v"
-	offsetPrefix   = "redissub:online:offset:%v:%v"
+	offsetPrefix   = "redissub:online:seq:%v:%v"
+	attemptPrefix  = "redissub:online:attempt:hash:%v:%v"
 )
 
 type (
 	Online struct {
-		Offset   *Offset
-		Waiter   *Waiter
-		Receiver *Receiver
+		Offset      *Offset
+		Waiter      *Waiter
+		Receiver    *Receiver
+		Attempts    *Attempts
+		DeadLetters *DeadLetters
 	}
 
 	Offset struct {
@@ -39,12 +42,25 @@ type (
 		Rdb        *red.Client
 		ExpireTime time.Duration // Key ttl
 	}
+
+	// Attempts keeps the redelivery state of waiter messages by event id.
+	Attempts struct {
+		Key        string
+		Rdb        *red.Client
+		ExpireTime time.Duration // Key ttl
+	}
+
+	Attempt struct {
+		Count  int   `json:"Count"`  // deliveries so far, the first live delivery included
+		NextAt int64 `json:"NextAt"` // unix milliseconds the next resend is due
+	}
 )
 
 func (r *Online) Ack(ctx context.Context, data *Event) {
 	r.Waiter.Del(ctx, data)
 	r.Receiver.Received(ctx, data)
 	r.Offset.UpdateOffset(ctx, data)
+	r.Attempts.Del(ctx, data.Id)
 }
 
 func (w *Waiter) Push(ctx context.Context, data []byte) {
@@ -75,12 +91,24 @@ func (w *Waiter) All(ctx context.Context) []interface{} {
 		var s2 Event
 		jsoniter.Unmarshal([]byte(a.(string)), &s1)
 		jsoniter.Unmarshal([]byte(b.(string)), &s2)
+		if s1.Seq != s2.Seq {
+			return int(s1.Seq - s2.Seq)
+		}
 		return int(s1.Time - s2.Time)
 	})
 
 	return strings
 }
 
+// Get returns the waiter message with id, nil when it is not waiting.
+func (w *Waiter) Get(ctx context.Context, id string) []byte {
+	result, err := w.Rdb.HGet(ctx, w.Key, id).Result()
+	if err != nil {
+		return nil
+	}
+	return []byte(result)
+}
+
 func (w *Waiter) Del(ctx context.Context, data *Event) {
 	w.Rdb.HDel(ctx, w.Key, data.Id)
 }
@@ -112,17 +140,35 @@ func (r *Receiver) IsReceived(ctx context.Context, data []byte) bool {
 	return false
 }
 
-func (o *Offset) UpdateOffset(ctx context.Context, data *Event) {
-	old := o.Rdb.Get(ctx, o.Key).Val()
-	oldTime, err := strconv.Atoi(old)
+// ReceivedIds returns which of ids are received, in one round trip.
+func (r *Receiver) ReceivedIds(ctx context.Context, ids []string) map[string]bool {
+	received := map[string]bool{}
+	if len(ids) == 0 {
+		return received
+	}
+	result, err := r.Rdb.HMGet(ctx, r.Key, ids...).Result()
 	if err != nil {
-		o.Rdb.Set(ctx, o.Key, strconv.Itoa(int(data.Time)), o.ExpireTime)
+		return received
+	}
+	for i, item := range result {
+		if value, ok := item.(string); ok && value != "" {
+			received[ids[i]] = true
+		}
+	}
+	return received
+}
+
+// UpdateOffset moves the offset forward to the event sequence number.
+func (o *Offset) UpdateOffset(ctx context.Context, data *Event) {
+	if data.Seq <= 0 {
 		return
 	}
-	if int64(oldTime) > data.Time {
+	old := o.Rdb.Get(ctx, o.Key).Val()
+	oldSeq, err := strconv.ParseInt(old, 10, 64)
+	if err == nil && oldSeq > data.Seq {
 		return
 	}
-	o.Rdb.Set(ctx, o.Key, strconv.Itoa(int(data.Time)), o.ExpireTime)
+	o.Rdb.Set(ctx, o.Key, strconv.FormatInt(data.Seq, 10), o.ExpireTime)
 }
 
 func (o *Offset) Offset(ctx context.Context) int64 {
@@ -130,11 +176,47 @@ func (o *Offset) Offset(ctx context.Context) int64 {
 	if err != nil {
 		return 0
 	}
-	convResult, err := strconv.Atoi(result)
+	convResult, err := strconv.ParseInt(result, 10, 64)
 	if err != nil {
 		return 0
 	}
-	return int64(convResult)
+	return convResult
+}
+
+// Get returns the attempts of ids, ids without state are missing from the result.
+func (a *Attempts) Get(ctx context.Context, ids []string) map[string]*Attempt {
+	attempts := map[string]*Attempt{}
+	if len(ids) == 0 {
+		return attempts
+	}
+	result, err := a.Rdb.HMGet(ctx, a.Key, ids...).Result()
+	if err != nil {
+		return attempts
+	}
+	for i, item := range result {
+		value, ok := item.(string)
+		if !ok {
+			continue
+		}
+		var attempt Attempt
+		if jsoniter.Unmarshal([]byte(value), &attempt) == nil {
+			attempts[ids[i]] = &attempt
+		}
+	}
+	return attempts
+}
+
+func (a *Attempts) Set(ctx context.Context, id string, attempt *Attempt) {
+	data, err := jsoniter.Marshal(attempt)
+	if err != nil {
+		return
+	}
+	a.Rdb.HSet(ctx, a.Key, id, string(data))
+	a.Rdb.Expire(ctx, a.Key, a.ExpireTime)
+}
+
+func (a *Attempts) Del(ctx context.Context, id string) {
+	a.Rdb.HDel(ctx, a.Key, id)
 }
 
 func GenWaiterKey(channel, id string) string {
@@ -148,3 +230,7 @@ func GenReceiverKey(channel, id string) string {
 func GenOffsetKey(channel, id string) string {
 	return fmt.Sprintf(offsetPrefix, channel, id)
 }
+
+func GenAttemptKey(channel, id string) string {
+	return fmt.Sprintf(attemptPrefix, channel, id)
+}
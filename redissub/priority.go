@@ -0,0 +1,86 @@
+package redissub
+
+import (
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Priority is the outbound lane of an event, writePump drains lanes in the order control, high, normal, bulk.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityControl
+	PriorityHigh
+	PriorityBulk
+)
+
+const (
+	laneControl = iota
+	laneHigh
+	laneNormal
+	laneBulk
+	laneCount
+)
+
+// After this many frames taken while a lower lane waits, one frame is taken from the lowest waiting lane.
+const starvationLimit = 8
+
+func (p Priority) lane() int {
+	switch p {
+	case PriorityControl:
+		return laneControl
+	case PriorityHigh:
+		return laneHigh
+	case PriorityBulk:
+		return laneBulk
+	default:
+		return laneNormal
+	}
+}
+
+func priorityOf(data []byte) Priority {
+	return Priority(jsoniter.Get(data, "Priority").ToInt())
+}
+
+// nextMessage takes the next queued frame without blocking, it is only called by the writer goroutine.
+func (c *Client) nextMessage() ([]byte, bool) {
+	if c.starved >= starvationLimit {
+		c.starved = 0
+		for i := laneCount - 1; i > laneControl; i-- {
+			select {
+			case message := <-c.lanes[i]:
+				return message, true
+			default:
+			}
+		}
+	}
+
+	for i, lane := range c.lanes {
+		select {
+		case message := <-lane:
+			if c.lowerWaiting(i) {
+				c.starved++
+			}
+			return message, true
+		default:
+		}
+	}
+	return nil, false
+}
+
+func (c *Client) lowerWaiting(lane int) bool {
+	for i := lane + 1; i < laneCount; i++ {
+		if len(c.lanes[i]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) queued() int {
+	n := 0
+	for _, lane := range c.lanes {
+		n += len(lane)
+	}
+	return n
+}
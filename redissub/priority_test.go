@@ -0,0 +1,88 @@
+package redissub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func newTestClient() *Client {
+	return MustNewClient(context.Background(), nil, "client", &SolidOption{})
+}
+
+func drain(c *Client) []string {
+	frames := []string{}
+	for {
+		message, ok := c.nextMessage()
+		if !ok {
+			return frames
+		}
+		frames = append(frames, string(message))
+	}
+}
+
+func TestNextMessageLaneOrder(t *testing.T) {
+	c := newTestClient()
+	c.DeliverPriority(PriorityBulk, []byte("bulk"))
+	c.DeliverPriority(PriorityNormal, []byte("normal"))
+	c.DeliverPriority(PriorityHigh, []byte("high"))
+	c.DeliverPriority(PriorityControl, []byte("control"))
+
+	got := fmt.Sprint(drain(c))
+	if want := "[control high normal bulk]"; got != want {
+		t.Fatalf("frames = %v, want %v", got, want)
+	}
+	if n := c.queued(); n != 0 {
+		t.Fatalf("queued = %d after drain", n)
+	}
+}
+
+func TestNextMessageStarvation(t *testing.T) {
+	c := newTestClient()
+	for i := 0; i < 2*starvationLimit; i++ {
+		c.DeliverPriority(PriorityHigh, []byte(fmt.Sprintf("high%d", i)))
+	}
+	c.DeliverPriority(PriorityBulk, []byte("bulk0"))
+	c.DeliverPriority(PriorityBulk, []byte("bulk1"))
+
+	frames := drain(c)
+	if len(frames) != 2*starvationLimit+2 {
+		t.Fatalf("got %d frames", len(frames))
+	}
+	// a waiting bulk frame gets a turn after every starvationLimit high frames
+	if frames[starvationLimit] != "bulk0" {
+		t.Fatalf("frame %d = %q, want bulk0: %v", starvationLimit, frames[starvationLimit], frames)
+	}
+	if frames[2*starvationLimit+1] != "bulk1" {
+		t.Fatalf("last frame = %q, want bulk1: %v", frames[2*starvationLimit+1], frames)
+	}
+}
+
+func TestNextMessageStarvationSkipsControl(t *testing.T) {
+	c := newTestClient()
+	c.starved = starvationLimit
+	c.DeliverPriority(PriorityControl, []byte("control"))
+	c.DeliverPriority(PriorityNormal, []byte("normal"))
+
+	// the starvation turn goes to the lowest waiting data lane, control never needs it
+	got := fmt.Sprint(drain(c))
+	if want := "[normal control]"; got != want {
+		t.Fatalf("frames = %v, want %v", got, want)
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		want Priority
+	}{
+		{`{"Priority":2}`, PriorityHigh},
+		{`{"Priority":3,"Data":"x"}`, PriorityBulk},
+		{`{"Data":"x"}`, PriorityNormal},
+		{`not json`, PriorityNormal},
+	} {
+		if got := priorityOf([]byte(tc.data)); got != tc.want {
+			t.Errorf("priorityOf(%s) = %v, want %v", tc.data, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package redissub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	red "github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"time"
+)
+
+const (
+	idempotencyPrefix = "redissub:idempotency:%v:%v"
+	seqPrefix         = "redissub:seq:%v"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+// publishScript writes the offline log and publishes in one step, so a crash can not leave one without the other.
+// The message gets the next channel sequence number, prepended as "Seq" to the json object and used as score.
+// A missing counter, new or lost, starts at the larger of ARGV[7] and the newest offline score, so it never
+// hands out a sequence below one a client may already have as offset.
+// When KEYS[3] is given and already set, a retried publish returns the first message id without publishing again.
+// It is only set after the writes, redis does not roll back a failed script, so a publish that errors can be retried.
+// KEYS[1] offline key, KEYS[2] sequence key, KEYS[3] optional idempotency key
+// ARGV[1] message json without Seq, ARGV[2] key ttl in milliseconds, 0 leaves the ttl, ARGV[3] channel,
+// ARGV[4] message id, ARGV[5] idempotency ttl in milliseconds, ARGV[6] max entries, 0 no limit,
+// ARGV[7] first sequence of a new counter
+// Returns {1, message id, seq, message} or {0, first message id} for a duplicate
+var publishScript = red.NewScript(`
+if KEYS[3] then
+	local first = redis.call('GET', KEYS[3])
+	if first then
+		return {0, first}
+	end
+end
+local seq = redis.call('INCR', KEYS[2])
+if seq == 1 then
+	seq = tonumber(ARGV[7])
+	local newest = redis.call('ZREVRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	if newest[2] and tonumber(newest[2]) >= seq then
+		seq = tonumber(newest[2]) + 1
+	end
+	redis.call('SET', KEYS[2], string.format('%d', seq))
+end
+local message = '{"Seq":' .. string.format('%d', seq) .. ',' .. string.sub(ARGV[1], 2)
+redis.call('ZADD', KEYS[1], seq, message)
+if tonumber(ARGV[6]) > 0 then
+	redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[6]) - 1)
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	redis.call('PEXPIRE', KEYS[2], ARGV[2])
+end
+if KEYS[3] then
+	redis.call('SET', KEYS[3], ARGV[4], 'PX', ARGV[5])
+end
+redis.call('PUBLISH', ARGV[3], message)
+return {1, ARGV[4], seq, message}
+`)
+
+var ErrNilEvent = errors.New("nil event")
+
+type (
+	MessageID string
+
+	PublishOptions struct {
+		// IdempotencyKey dedupes retried publishes on the same channel, default is the caller supplied Event.Id
+		IdempotencyKey string
+		// IdempotencyTTL is how long the key is remembered, default SolidOption.ExpireTime
+		IdempotencyTTL time.Duration
+	}
+
+	ChannelEvent struct {
+		Channel        string
+		Event          *Event
+		IdempotencyKey string // see PublishOptions
+	}
+
+	PublishResult struct {
+		Id  MessageID
+		Err error
+	}
+
+	publishCall struct {
+		channel string
+		event   *Event
+		data    []byte
+		keys    []string
+		args    []interface{}
+	}
+)
+
+// Publish stores event in the channel offline log and publishes it atomically.
+// Empty Id and Time are stamped before publishing, Seq is always assigned, the assigned Id is returned.
+func (p *PubSubClient) Publish(ctx context.Context, channel string, event *Event) (MessageID, error) {
+	return p.PublishWithOptions(ctx, channel, event, PublishOptions{})
+}
+
+// PublishWithOptions is Publish with an idempotency key, a publish retried with the same key
+// neither publishes nor stores the message again, and returns the id of the first one.
+func (p *PubSubClient) PublishWithOptions(ctx context.Context, channel string, event *Event, opts PublishOptions) (MessageID, error) {
+	call, err := p.newPublishCall(channel, event, opts)
+	if err != nil {
+		return "", err
+	}
+	p.expectEcho(call)
+	id, published, err := call.result(publishScript.Run(ctx, p.Publisher, call.keys, call.args...))
+	if err != nil || !published {
+		p.forgetEcho(call)
+		return id, err
+	}
+	p.deliverLocal(call)
+	return id, nil
+}
+
+// PublishBatch publishes many events in one pipelined round trip, results are in the order of events.
+// The returned error is only set when the batch could not be sent at all.
+func (p *PubSubClient) PublishBatch(ctx context.Context, events []ChannelEvent) ([]PublishResult, error) {
+	results := make([]PublishResult, len(events))
+	if len(events) == 0 {
+		return results, nil
+	}
+	if err := publishScript.Load(ctx, p.Publisher).Err(); err != nil {
+		return nil, err
+	}
+
+	pipe := p.Publisher.Pipeline()
+	cmds := make([]*red.Cmd, len(events))
+	calls := make([]*publishCall, len(events))
+	for i, item := range events {
+		call, err := p.newPublishCall(item.Channel, item.Event, PublishOptions{IdempotencyKey: item.IdempotencyKey})
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		calls[i] = call
+		p.expectEcho(call)
+		cmds[i] = publishScript.EvalSha(ctx, pipe, call.keys, call.args...)
+	}
+	_, _ = pipe.Exec(ctx) // errors are reported per command
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		id, published, err := calls[i].result(cmd)
+		results[i] = PublishResult{Id: id, Err: err}
+		if err != nil || !published {
+			p.forgetEcho(calls[i])
+			continue
+		}
+		p.deliverLocal(calls[i])
+	}
+	return results, nil
+}
+
+func (p *PubSubClient) newPublishCall(channel string, event *Event, opts PublishOptions) (*publishCall, error) {
+	if event == nil {
+		return nil, ErrNilEvent
+	}
+	idempotencyKey := opts.IdempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = event.Id // a stamped id is new anyway
+	}
+	stampEvent(event)
+	event.Channel = channel
+	event.Seq = 0 // assigned by the script
+	data, err := jsoniter.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{GenOfflineKey(channel), GenSeqKey(channel)}
+	if idempotencyKey != "" {
+		keys = append(keys, GenIdempotencyKey(channel, idempotencyKey))
+	}
+	idempotencyTTL := opts.IdempotencyTTL
+	if idempotencyTTL <= 0 {
+		idempotencyTTL = p.SolidOption.ExpireTime
+	}
+	if idempotencyTTL <= 0 {
+		idempotencyTTL = defaultIdempotencyTTL
+	}
+	ttl := p.SolidOption.ExpireTime.Milliseconds()
+	maxEntries := int64(0)
+	if policy := p.RetentionOption.policy(channel); policy != nil {
+		if policy.MaxAge > 0 {
+			ttl = policy.MaxAge.Milliseconds()
+		}
+		maxEntries = policy.MaxEntries
+	}
+	return &publishCall{
+		channel: channel,
+		event:   event,
+		data:    data,
+		keys:    keys,
+		args: []interface{}{string(data), ttl, channel,
+			event.Id, idempotencyTTL.Milliseconds(), maxEntries, firstSeq()},
+	}, nil
+}
+
+// result reads the script reply, a published message updates the event Seq and the data delivered locally.
+func (call *publishCall) result(cmd *red.Cmd) (MessageID, bool, error) {
+	values, err := cmd.Slice()
+	if err != nil {
+		return "", false, err
+	}
+	if len(values) < 2 {
+		return "", false, fmt.Errorf("unexpected publish result %v", values)
+	}
+	published, _ := values[0].(int64)
+	id, _ := values[1].(string)
+	if published != 1 {
+		return MessageID(id), false, nil
+	}
+	if len(values) != 4 {
+		return "", false, fmt.Errorf("unexpected publish result %v", values)
+	}
+	call.event.Seq, _ = values[2].(int64)
+	message, _ := values[3].(string)
+	call.data = []byte(message)
+	return MessageID(id), true, nil
+}
+
+func stampEvent(event *Event) {
+	if event.Id == "" {
+		event.Id = genMessageId()
+	}
+	if event.Time == 0 {
+		event.Time = time.Now().UnixMilli()
+	}
+}
+
+func genMessageId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// firstSeq starts a channel counter at the clock in microseconds, above every sequence handed out by a lost
+// counter unless it averaged more than one message per microsecond, and exact as a zset score for centuries.
+func firstSeq() int64 {
+	return time.Now().UnixMicro()
+}
+
+func GenSeqKey(channel string) string {
+	return fmt.Sprintf(seqPrefix, channel)
+}
+
+func GenIdempotencyKey(channel, key string) string {
+	return fmt.Sprintf(idempotencyPrefix, channel, key)
+}
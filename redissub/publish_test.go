@@ -0,0 +1,221 @@
+package redissub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPublishStoresAndPublishes(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	sub := rdb.Subscribe(ctx, "room")
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &Event{EventName: "chat", Data: "hello"}
+	id, err := p.Publish(ctx, "room", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || string(id) != event.Id || event.Time == 0 || event.Seq == 0 || event.Channel != "room" {
+		t.Fatalf("event not stamped: id %q %+v", id, event)
+	}
+
+	stored, err := rdb.ZRange(ctx, GenOfflineKey("room"), 0, -1).Result()
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("offline log = %v, %v", stored, err)
+	}
+	if score := rdb.ZScore(ctx, GenOfflineKey("room"), stored[0]).Val(); int64(score) != event.Seq {
+		t.Fatalf("score = %v, want seq %d", score, event.Seq)
+	}
+	if ttl := m.TTL(GenOfflineKey("room")); ttl != time.Hour {
+		t.Fatalf("offline ttl = %v", ttl)
+	}
+
+	select {
+	case message := <-sub.Channel():
+		// subscribers get exactly what is stored, Seq included
+		if message.Payload != stored[0] {
+			t.Fatalf("published %s, stored %s", message.Payload, stored[0])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing published")
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	events := []ChannelEvent{
+		{Channel: "a", Event: &Event{Data: "1"}},
+		{Channel: "b", Event: &Event{Data: "2"}},
+		{Channel: "a", Event: nil},
+		{Channel: "a", Event: &Event{Data: "3"}, IdempotencyKey: "k"},
+		{Channel: "a", Event: &Event{Data: "4"}, IdempotencyKey: "k"},
+	}
+	results, err := p.PublishBatch(ctx, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(events) {
+		t.Fatalf("got %d results", len(results))
+	}
+	for _, i := range []int{0, 1, 3, 4} {
+		if results[i].Err != nil || results[i].Id == "" {
+			t.Fatalf("result %d = %+v", i, results[i])
+		}
+	}
+	if results[2].Err != ErrNilEvent {
+		t.Fatalf("nil event result = %+v", results[2])
+	}
+	if results[4].Id != results[3].Id {
+		t.Fatalf("duplicate in batch = %v, want %v", results[4].Id, results[3].Id)
+	}
+	// events of one channel are sequenced in batch order
+	if events[3].Event.Seq != events[0].Event.Seq+1 {
+		t.Fatalf("seqs %d %d not consecutive", events[0].Event.Seq, events[3].Event.Seq)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("a")).Val(); n != 2 {
+		t.Fatalf("a stored %d messages, want 2", n)
+	}
+}
+
+func TestPublishIdempotent(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	first, err := p.PublishWithOptions(ctx, "room", &Event{Data: "1"}, PublishOptions{IdempotencyKey: "order-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retried, err := p.PublishWithOptions(ctx, "room", &Event{Data: "1"}, PublishOptions{IdempotencyKey: "order-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried != first {
+		t.Fatalf("retried publish = %v, want first id %v", retried, first)
+	}
+
+	// a caller supplied Event.Id is the default key
+	p.Publish(ctx, "room", &Event{Id: "fixed"})
+	if id, _ := p.Publish(ctx, "room", &Event{Id: "fixed", Data: "again"}); id != "fixed" {
+		t.Fatalf("duplicate id = %v", id)
+	}
+
+	// the key is per channel
+	if _, err := p.PublishWithOptions(ctx, "other", &Event{}, PublishOptions{IdempotencyKey: "order-7"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := rdb.ZCard(ctx, GenOfflineKey("room")).Val(); n != 2 {
+		t.Fatalf("room stored %d messages, want 2", n)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("other")).Val(); n != 1 {
+		t.Fatalf("other stored %d messages, want 1", n)
+	}
+	if ttl := rdb.PTTL(ctx, GenIdempotencyKey("room", "order-7")).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("idempotency ttl = %v, want the offline ttl", ttl)
+	}
+}
+
+func TestPublishRetentionMaxEntries(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	p.RetentionOption = &RetentionOption{Rules: []RetentionRule{
+		{Pattern: "capped:*", Policy: RetentionPolicy{MaxEntries: 2}},
+	}}
+	ctx := context.Background()
+
+	last := &Event{}
+	for i := 0; i < 4; i++ {
+		last = &Event{}
+		if _, err := p.Publish(ctx, "capped:1", last); err != nil {
+			t.Fatal(err)
+		}
+		p.Publish(ctx, "free", &Event{})
+	}
+
+	scores := rdb.ZRangeWithScores(ctx, GenOfflineKey("capped:1"), 0, -1).Val()
+	if len(scores) != 2 || int64(scores[1].Score) != last.Seq {
+		t.Fatalf("capped log = %v, want the 2 newest", scores)
+	}
+	// MaxAge 0 keeps the ExpireTime ttl on the log and counter
+	if ttl := m.TTL(GenOfflineKey("capped:1")); ttl != time.Hour {
+		t.Fatalf("capped ttl = %v, want ExpireTime", ttl)
+	}
+	if ttl := m.TTL(GenSeqKey("capped:1")); ttl != time.Hour {
+		t.Fatalf("capped seq ttl = %v, want ExpireTime", ttl)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("free")).Val(); n != 4 {
+		t.Fatalf("free stored %d messages, want 4", n)
+	}
+}
+
+func TestPublishRetentionMaxAgeTtl(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	p.RetentionOption = &RetentionOption{Interval: time.Minute, Rules: []RetentionRule{
+		{Pattern: "short:*", Policy: RetentionPolicy{MaxAge: time.Minute}},
+	}}
+
+	p.Publish(context.Background(), "short:1", &Event{})
+	if ttl := m.TTL(GenOfflineKey("short:1")); ttl != time.Minute {
+		t.Fatalf("offline ttl = %v, want MaxAge", ttl)
+	}
+	if ttl := m.TTL(GenSeqKey("short:1")); ttl != time.Minute {
+		t.Fatalf("seq ttl = %v, want MaxAge", ttl)
+	}
+}
+
+func TestRetentionNeedsTrimmer(t *testing.T) {
+	for _, tc := range []struct {
+		option *RetentionOption
+		panics bool
+	}{
+		{nil, false},
+		{&RetentionOption{Rules: []RetentionRule{{Pattern: "*", Policy: RetentionPolicy{MaxEntries: 10}}}}, false},
+		{&RetentionOption{Rules: []RetentionRule{{Pattern: "*", Policy: RetentionPolicy{MaxBytes: 10}}}}, true},
+		{&RetentionOption{Rules: []RetentionRule{{Pattern: "*", Policy: RetentionPolicy{MaxAge: time.Hour}}}}, true},
+		{&RetentionOption{Interval: time.Minute, Rules: []RetentionRule{{Pattern: "*", Policy: RetentionPolicy{MaxBytes: 10}}}}, false},
+	} {
+		panicked := func() (panicked bool) {
+			defer func() { panicked = recover() != nil }()
+			tc.option.mustTrim()
+			return false
+		}()
+		if panicked != tc.panics {
+			t.Errorf("mustTrim(%+v) panicked = %v, want %v", tc.option, panicked, tc.panics)
+		}
+	}
+}
+
+func TestPublishRetryAfterError(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	// a key of the wrong type fails the script after the idempotency check
+	rdb.Set(ctx, GenOfflineKey("room"), "broken", 0)
+	if _, err := p.Publish(ctx, "room", &Event{Id: "once"}); err == nil {
+		t.Fatal("publish to a broken log succeeded")
+	}
+	if rdb.Exists(ctx, GenIdempotencyKey("room", "once")).Val() != 0 {
+		t.Fatal("failed publish claimed its idempotency key")
+	}
+
+	rdb.Del(ctx, GenOfflineKey("room"))
+	if _, err := p.Publish(ctx, "room", &Event{Id: "once"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("room")).Val(); n != 1 {
+		t.Fatalf("retried publish stored %d messages, want 1", n)
+	}
+}
@@ -3,25 +3,42 @@ package redissub
 import (
 	"context"
 	red "github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"log"
 	"math"
+	"net/http"
 	"sync"
-	"sync/atomic"
+	"time"
 )
 
 type Reviver func(key string, value string) interface{}
 
 type PubSubRedisOptions struct {
-	Publisher   *red.Client
-	Subscriber  *red.Client
-	SolidOption *SolidOption
+	Publisher          *red.Client
+	Subscriber         *red.Client
+	SolidOption        *SolidOption
+	DispatchOption     *DispatchOption
+	BackpressureOption *BackpressureOption
+	ScheduleOption     *ScheduleOption // nil disables the scheduled publish poller on this node
+	SweepOption        *SweepOption    // nil disables the expired message sweeper on this node
+	OnConnect          OnConnect       // called for every new client before its pumps start
+	LocalDelivery      bool            // deliver to this node listeners on Publish, remote nodes still get it through redis
+	RetentionOption    *RetentionOption
 }
 
 type OnMessage func(client *Client, data []byte)
 
+type OnConnect func(r *http.Request, client *Client)
+
 type Listener struct {
 	Client    *Client
 	Channel   string
 	OnMessage OnMessage
+	Filter    *Filter // nil delivers every message
+
+	// In process subscribers have no Client
+	Handler  EventHandler
+	Consumer *Consumer // nil unless durable
 }
 
 type PubSubClient struct {
@@ -32,47 +49,102 @@ type PubSubClient struct {
 	subId       int64
 	PubSub      *red.PubSub
 	mu          sync.Mutex
-	DropRun     chan struct{}
 	SolidOption *SolidOption
+	Dispatcher  *Dispatcher
+	NodeId      string
+
+	BackpressureOption *BackpressureOption
+	OnConnect          OnConnect
+	RetentionOption    *RetentionOption
+
+	mwMu     sync.RWMutex
+	inbound  []Middleware
+	outbound []DeliveryMiddleware
+
+	localEcho *localEcho // nil unless LocalDelivery
+
+	clientsMu sync.Mutex
+	clients   map[*Client]struct{}
+	done      chan struct{}
+	closing   int32
+	wg        sync.WaitGroup
 }
 
+// NewPubSubClient starts the client and its background tasks, it panics on a RetentionOption it can not enforce.
 func NewPubSubClient(pubSubRedisOptions PubSubRedisOptions) *PubSubClient {
+	pubSubRedisOptions.RetentionOption.mustTrim()
 	pubSubClient := &PubSubClient{
 		Publisher:   pubSubRedisOptions.Publisher,
 		Subscriber:  pubSubRedisOptions.Subscriber,
 		subMap:      map[int64]*Listener{},
 		subsRefsMap: map[string][]int64{},
 		subId:       int64(0),
-		PubSub:      pubSubRedisOptions.Subscriber.Subscribe(context.Background()),
-		DropRun:     make(chan struct{}, 0),
+		PubSub:      pubSubRedisOptions.Subscriber.Subscribe(context.Background(), controlChannel),
 		SolidOption: pubSubRedisOptions.SolidOption, // Key ttl
+		Dispatcher:  NewDispatcher(pubSubRedisOptions.DispatchOption),
+		NodeId:      genMessageId(),
+
+		BackpressureOption: pubSubRedisOptions.BackpressureOption,
+		OnConnect:          pubSubRedisOptions.OnConnect,
+		RetentionOption:    pubSubRedisOptions.RetentionOption,
+
+		clients: map[*Client]struct{}{},
+		done:    make(chan struct{}),
+	}
+	if pubSubRedisOptions.LocalDelivery {
+		pubSubClient.localEcho = newLocalEcho()
 	}
 
-	GoSafe(func() {
+	pubSubClient.goSafe(func() {
 		pubSubClient.Run()
 	})
+	if scheduleOption := pubSubRedisOptions.ScheduleOption; scheduleOption != nil {
+		pubSubClient.goSafe(func() {
+			pubSubClient.runScheduler(scheduleOption)
+		})
+	}
+	if sweepOption := pubSubRedisOptions.SweepOption; sweepOption != nil {
+		pubSubClient.goSafe(func() {
+			pubSubClient.runSweeper(sweepOption)
+		})
+	}
+	if retentionOption := pubSubRedisOptions.RetentionOption; retentionOption != nil && retentionOption.Interval > 0 {
+		pubSubClient.goSafe(func() {
+			pubSubClient.runTrimmer(retentionOption)
+		})
+	}
 	return pubSubClient
 }
 
 func (p *PubSubClient) Subscribe(client *Client, channel string, onMessage OnMessage) int64 {
+	return p.SubscribeWithFilter(client, channel, onMessage, nil)
+}
+
+// SubscribeWithFilter subscribes client to channel, messages not matching filter never reach its waiter or onMessage.
+func (p *PubSubClient) SubscribeWithFilter(client *Client, channel string, onMessage OnMessage, filter *Filter) int64 {
+	return p.subscribeListener(&Listener{Client: client, Channel: channel, OnMessage: onMessage, Filter: filter})
+}
+
+func (p *PubSubClient) subscribeListener(listener *Listener) int64 {
+	channel := listener.Channel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if p.subId >= math.MaxInt64 {
 		p.subId = 0
 	}
-	atomic.AddInt64(&p.subId, 1)
-	p.mu.Lock()
-	defer func() {
-		p.reSubscribe()
-		p.mu.Unlock()
-	}()
+	p.subId++
+	id := p.subId
 
-	p.subMap[p.subId] = &Listener{client, channel, onMessage}
+	p.subMap[id] = listener
 	if _, ok := p.subsRefsMap[channel]; ok {
-		p.subsRefsMap[channel] = append(p.subsRefsMap[channel], p.subId)
-		return p.subId
+		p.subsRefsMap[channel] = append(p.subsRefsMap[channel], id)
+		return id
 	}
 
-	p.subsRefsMap[channel] = []int64{p.subId}
-	return p.subId
+	p.subsRefsMap[channel] = []int64{id}
+	p.redisSubscribe(channel)
+	return id
 }
 
 func (p *PubSubClient) UnSubscribe(id int64) {
@@ -91,59 +163,107 @@ func (p *PubSubClient) UnSubscribe(id int64) {
 			// empty
 			if len(p.subsRefsMap[channel]) == 0 {
 				delete(p.subsRefsMap, channel)
-				GoSafe(func() {
-					p.reSubscribe()
-				})
+				p.redisUnsubscribe(channel)
 			}
 		}
 	}
 	delete(p.subMap, id)
 }
 
-func (p *PubSubClient) Publish(ctx context.Context, channel string, message []byte) {
-	p.Publisher.Publish(ctx, channel, message)
-	offline := &OffLine{
-		ExpireTime: p.SolidOption.ExpireTime,
-		Rdb:        p.Publisher,
-		Key:        GenOfflineKey(channel),
+// redisSubscribe adds channel to the shared redis subscription, other channels keep receiving meanwhile.
+// It is called with p.mu held so subscribe and unsubscribe of one channel reach redis in order.
+func (p *PubSubClient) redisSubscribe(channel string) {
+	if p.isClosing() {
+		return
+	}
+	if err := p.PubSub.Subscribe(context.Background(), channel); err != nil {
+		log.Printf("subscribe %v error: %v", channel, err)
 	}
-	offline.AddToOffline(ctx, message)
 }
 
-func (p *PubSubClient) reSubscribe() {
-	channels := getKeys(p.subsRefsMap)
-	p.PubSub.Close()
-	p.PubSub = p.Subscriber.Subscribe(context.Background(), channels...)
-	p.DropRun <- struct{}{}
+// redisUnsubscribe drops channel from the shared redis subscription once it has no listener, called with p.mu held.
+func (p *PubSubClient) redisUnsubscribe(channel string) {
+	if p.isClosing() {
+		return
+	}
+	if err := p.PubSub.Unsubscribe(context.Background(), channel); err != nil {
+		log.Printf("unsubscribe %v error: %v", channel, err)
+	}
 }
 
 func (p *PubSubClient) Run() {
 	for {
 		select {
 		case msg, ok := <-p.PubSub.Channel():
-			if ok {
-				channel := msg.Channel
-				payLoad := msg.Payload
-
-				if ids, ok := p.subsRefsMap[channel]; ok {
-					for _, id := range ids {
-						if listener := p.subMap[id]; ok {
-							listener.Client.Solid.Push(context.Background(), channel, []byte(payLoad))
-							listener.OnMessage(listener.Client, []byte(payLoad))
-						}
-					}
-				}
+			if !ok {
+				break
+			}
+			if msg.Channel == controlChannel {
+				p.handleControl([]byte(msg.Payload))
+				break
 			}
-		case <-p.DropRun:
-			break
+			if p.localEcho != nil && p.localEcho.take(jsoniter.Get([]byte(msg.Payload), "Id").ToString()) {
+				break // already delivered by Publish
+			}
+			p.dispatch(msg.Channel, []byte(msg.Payload))
+		case <-p.done:
+			return
 		}
 	}
 }
 
-func getKeys(m map[string][]int64) []string {
-	var ret = []string{}
-	for k := range m {
-		ret = append(ret, k)
+// dispatch hands the message to the worker pool, sharded by client id to keep per-client order
+func (p *PubSubClient) dispatch(channel string, payLoad []byte) {
+	p.dispatchWith(channel, payLoad, p.Dispatcher.Dispatch)
+}
+
+// dispatchWith is dispatch queueing each listener task with enqueue.
+func (p *PubSubClient) dispatchWith(channel string, payLoad []byte, enqueue func(key string, fn func()) error) {
+	var event Event
+	if err := jsoniter.Unmarshal(payLoad, &event); err == nil && event.Expired(time.Now()) {
+		return
+	}
+	for _, listener := range p.listeners(channel) {
+		l := listener
+		if l.Filter != nil && !l.Filter.Match(&event) {
+			continue
+		}
+		if l.Client == nil {
+			enqueue(l.key(), func() {
+				consume(context.Background(), l.Consumer, l.Handler, payLoad)
+			})
+			continue
+		}
+		enqueue(l.key(), func() {
+			deliver := p.outboundChain(func(client *Client, channel string, data []byte) {
+				client.Solid.Push(context.Background(), channel, data)
+				l.OnMessage(client, data)
+			})
+			deliver(l.Client, channel, payLoad)
+		})
+	}
+}
+
+// key shards deliveries so each client, consumer or in process channel subscriber keeps its order
+func (l *Listener) key() string {
+	switch {
+	case l.Client != nil:
+		return l.Client.Id
+	case l.Consumer != nil:
+		return "consumer:" + l.Consumer.Id
+	default:
+		return "func:" + l.Channel
+	}
+}
+
+func (p *PubSubClient) listeners(channel string) []*Listener {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	listeners := []*Listener{}
+	for _, id := range p.subsRefsMap[channel] {
+		if listener, ok := p.subMap[id]; ok {
+			listeners = append(listeners, listener)
+		}
 	}
-	return ret
+	return listeners
 }
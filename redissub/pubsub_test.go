@@ -0,0 +1,93 @@
+package redissub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribeIsIncremental(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	received := make(chan string, 10)
+	p.SubscribeFunc("steady", func(ctx context.Context, event *Event) error {
+		received <- event.Data
+		return nil
+	})
+	waitChannels(t, m, "redissub:control", "steady")
+
+	ids := []int64{}
+	for i := 0; i < 3; i++ {
+		ids = append(ids, p.SubscribeFunc(fmt.Sprintf("inbox:%d", i), func(ctx context.Context, event *Event) error { return nil }))
+	}
+	waitChannels(t, m, "inbox:0", "inbox:1", "inbox:2", "redissub:control", "steady")
+
+	p.UnSubscribe(ids[1])
+	waitChannels(t, m, "inbox:0", "inbox:2", "redissub:control", "steady")
+
+	// a second listener keeps the channel subscribed
+	extra := p.SubscribeFunc("inbox:0", func(ctx context.Context, event *Event) error { return nil })
+	p.UnSubscribe(extra)
+	waitChannels(t, m, "inbox:0", "inbox:2", "redissub:control", "steady")
+
+	p.Publish(ctx, "steady", &Event{Data: "still here"})
+	select {
+	case data := <-received:
+		if data != "still here" {
+			t.Fatalf("received %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("steady channel stopped receiving")
+	}
+}
+
+func waitChannels(t *testing.T, m interface{ PubSubChannels(string) []string }, want ...string) {
+	t.Helper()
+	sort.Strings(want)
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := m.PubSubChannels("")
+		sort.Strings(got)
+		if fmt.Sprint(got) == fmt.Sprint(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscribed channels = %v, want %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConcurrentSubscribeIds(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+
+	const n = 50
+	ids := make(chan int64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- p.SubscribeFunc("room", func(ctx context.Context, event *Event) error { return nil })
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[int64]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("subscribe id %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if got := len(p.listeners("room")); got != n {
+		t.Fatalf("listeners = %d, want %d", got, n)
+	}
+}
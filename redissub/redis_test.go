@@ -0,0 +1,55 @@
+package redissub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	red "github.com/go-redis/redis/v8"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *red.Client) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	rdb := red.NewClient(&red.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return m, rdb
+}
+
+func newTestPubSub(t *testing.T, rdb *red.Client) *PubSubClient {
+	t.Helper()
+	p := NewPubSubClient(PubSubRedisOptions{
+		Publisher:   rdb,
+		Subscriber:  rdb,
+		SolidOption: &SolidOption{ExpireTime: time.Hour, Duration: time.Second, Rdb: rdb},
+	})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		p.Shutdown(ctx)
+	})
+	return p
+}
+
+// newTestSubscriber is a client without connection subscribed to channels, for the storage paths.
+func newTestSubscriber(p *PubSubClient, id string, channels ...string) *Client {
+	c := MustNewClient(context.Background(), nil, id, p.SolidOption)
+	for _, channel := range channels {
+		c.Subscribe(channel)
+	}
+	return c
+}
+
+func waiterIds(t *testing.T, rdb *red.Client, channel, clientId string) map[string]bool {
+	t.Helper()
+	ids, err := rdb.HKeys(context.Background(), GenWaiterKey(channel, clientId)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]bool{}
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
@@ -0,0 +1,98 @@
+package redissub
+
+import (
+	"context"
+	"errors"
+	red "github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"strconv"
+	"time"
+)
+
+const (
+	resyncEvent = "resync"
+
+	// maxResyncBatch bounds one resync answer, the client resyncs again from To for the rest
+	maxResyncBatch = 1000
+)
+
+var ErrNotSubscribed = errors.New("not subscribed")
+
+type (
+	// ResyncRequest is the Data of a resync event, Seq is the last contiguous sequence the client has.
+	ResyncRequest struct {
+		Channel string `json:"Channel"`
+		Seq     int64  `json:"Seq"`
+	}
+
+	// ResyncResult is the Data of the resync frame sent after the missing messages.
+	ResyncResult struct {
+		Channel string `json:"Channel"`
+		From    int64  `json:"From"` // first sequence sent, 0 when nothing was missing
+		To      int64  `json:"To"`   // last sequence sent, resync from To again when More
+		More    bool   `json:"More"`
+		// Truncated is set when messages after the requested Seq are no longer in the offline log
+		Truncated bool `json:"Truncated"`
+	}
+)
+
+// Resync sends the messages of channel after seq from the offline log, followed by a resync frame with a ResyncResult.
+// Resent messages go to the waiter like live ones, so they are acked and redelivered the same way,
+// messages already acked are not resent.
+func (s *Solid) Resync(ctx context.Context, channel string, seq int64) (*ResyncResult, error) {
+	if !s.Client.subscribed(channel) {
+		return nil, ErrNotSubscribed
+	}
+	result, err := s.Rdb.ZRangeByScoreWithScores(ctx, GenOfflineKey(channel), &red.ZRangeBy{
+		Min:   "(" + strconv.FormatInt(seq, 10),
+		Max:   "+inf",
+		Count: maxResyncBatch + 1,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	answer := &ResyncResult{Channel: channel}
+	if len(result) > maxResyncBatch {
+		result = result[:maxResyncBatch]
+		answer.More = true
+	}
+	online := s.online(channel)
+	filter := s.Client.filter(channel)
+	now := time.Now()
+	ids := make([]string, len(result))
+	for i, item := range result {
+		if member, ok := item.Member.(string); ok {
+			ids[i] = jsoniter.Get([]byte(member), "Id").ToString()
+		}
+	}
+	received := online.Receiver.ReceivedIds(ctx, ids)
+	for i, item := range result {
+		member, ok := item.Member.(string)
+		if !ok {
+			continue
+		}
+		score := int64(item.Score)
+		if i == 0 {
+			answer.From = score
+			answer.Truncated = seq > 0 && score > seq+1 // counters start anywhere, only a known seq shows a gap
+		}
+		answer.To = score
+		data := []byte(member)
+		if received[ids[i]] || !deliverable(data, now, filter) {
+			continue
+		}
+		online.Waiter.Push(ctx, data)
+		if err := s.Client.Deliver(data); err != nil {
+			return nil, err // still in waiter, resent later
+		}
+	}
+
+	resultData, _ := jsoniter.Marshal(answer)
+	frame, _ := jsoniter.Marshal(&Event{
+		EventName: resyncEvent,
+		Data:      string(resultData),
+		Channel:   channel,
+	})
+	return answer, s.Client.DeliverPriority(PriorityNormal, frame) // behind the normal priority messages it reports
+}
@@ -0,0 +1,50 @@
+package redissub
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResyncSkipsReceivedAndFiltered(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	p.Publish(ctx, "orders", &Event{Id: "acked", Data: `{"status":"shipped"}`})
+	p.Publish(ctx, "orders", &Event{Id: "pending", Data: `{"status":"pending"}`})
+	p.Publish(ctx, "orders", &Event{Id: "shipped", Data: `{"status":"shipped"}`})
+
+	filter, err := CompileFilter(`data.status == "shipped"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestSubscriber(p, "u1", "orders")
+	c.filters["orders"] = filter
+	c.Solid.online("orders").Receiver.Received(ctx, &Event{Id: "acked"})
+
+	answer, err := c.Solid.Resync(ctx, "orders", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.To-answer.From != 2 || answer.More || answer.Truncated {
+		t.Fatalf("answer = %+v", answer)
+	}
+	got := waiterIds(t, rdb, "orders", "u1")
+	if len(got) != 1 || !got["shipped"] {
+		t.Fatalf("waiter = %v, want only shipped", got)
+	}
+	// the resent message, then the resync frame
+	if frames := drain(c); len(frames) != 2 {
+		t.Fatalf("frames = %v", frames)
+	}
+}
+
+func TestResyncNotSubscribed(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+
+	c := newTestSubscriber(p, "u1", "orders")
+	if _, err := c.Solid.Resync(context.Background(), "other", 0); err != ErrNotSubscribed {
+		t.Fatalf("err = %v, want ErrNotSubscribed", err)
+	}
+}
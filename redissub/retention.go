@@ -0,0 +1,158 @@
+package redissub
+
+import (
+	"context"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	retentionLeaderKey = "redissub:retention:leader"
+
+	retentionBatch = 100
+)
+
+type (
+	RetentionPolicy struct {
+		MaxEntries int64         // keep at most this many messages, enforced on every publish, 0 no limit
+		MaxAge     time.Duration // drop older messages, also the key ttl, 0 keeps SolidOption.ExpireTime as ttl
+		MaxBytes   int64         // cap on the summed message sizes, 0 no limit
+	}
+
+	RetentionRule struct {
+		Pattern string // path.Match pattern of channel names, e.g. "room:*"
+		Policy  RetentionPolicy
+	}
+
+	RetentionOption struct {
+		Rules []RetentionRule // first matching rule wins, channels without a rule keep SolidOption.ExpireTime
+		// Interval is the background trim interval enforcing MaxAge and MaxBytes, only the elected node trims.
+		// It is required when a rule sets MaxAge or MaxBytes, NewPubSubClient panics without it.
+		Interval time.Duration
+	}
+)
+
+// mustTrim panics on rules the background trimmer has to enforce while it is off, they would keep every message.
+func (o *RetentionOption) mustTrim() {
+	if o == nil || o.Interval > 0 {
+		return
+	}
+	for _, rule := range o.Rules {
+		if rule.Policy.MaxAge > 0 || rule.Policy.MaxBytes > 0 {
+			panic(fmt.Sprintf("redissub: retention rule %q sets MaxAge or MaxBytes without RetentionOption.Interval", rule.Pattern))
+		}
+	}
+}
+
+func (o *RetentionOption) policy(channel string) *RetentionPolicy {
+	if o == nil {
+		return nil
+	}
+	for i := range o.Rules {
+		if ok, _ := path.Match(o.Rules[i].Pattern, channel); ok {
+			return &o.Rules[i].Policy
+		}
+	}
+	return nil
+}
+
+// runTrimmer enforces retention policies of every offline log on the elected node.
+func (p *PubSubClient) runTrimmer(option *RetentionOption) {
+	leader := &Leader{
+		Key: retentionLeaderKey,
+		Id:  p.NodeId,
+		Rdb: p.Publisher,
+		Ttl: 3 * option.Interval,
+	}
+
+	ticker := time.NewTicker(option.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx := context.Background()
+			if !leader.Acquire(ctx) {
+				continue
+			}
+			p.trimOffline(ctx, option)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *PubSubClient) trimOffline(ctx context.Context, option *RetentionOption) {
+	prefix := fmt.Sprintf(offlinePrefix, "")
+	iter := p.Publisher.Scan(ctx, 0, prefix+"*", sweepScanCount).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		policy := option.policy(strings.TrimPrefix(key, prefix))
+		if policy == nil {
+			continue
+		}
+		offline := &OffLine{
+			Rdb: p.Publisher,
+			Key: key,
+		}
+		offline.Trim(ctx, policy)
+	}
+}
+
+// Trim applies policy to the offline log.
+func (o *OffLine) Trim(ctx context.Context, policy *RetentionPolicy) {
+	if policy.MaxEntries > 0 {
+		o.Rdb.ZRemRangeByRank(ctx, o.Key, 0, -policy.MaxEntries-1)
+	}
+	if policy.MaxAge > 0 {
+		o.trimAge(ctx, time.Now().Add(-policy.MaxAge).UnixMilli())
+	}
+	if policy.MaxBytes > 0 {
+		o.trimBytes(ctx, policy.MaxBytes)
+	}
+}
+
+// trimAge drops the oldest messages with Time before cutoff, walking from the head of the log.
+func (o *OffLine) trimAge(ctx context.Context, cutoff int64) {
+	for {
+		members, err := o.Rdb.ZRange(ctx, o.Key, 0, retentionBatch-1).Result()
+		if err != nil || len(members) == 0 {
+			return
+		}
+		expired := 0
+		for _, member := range members {
+			if jsoniter.Get([]byte(member), "Time").ToInt64() >= cutoff {
+				break
+			}
+			expired++
+		}
+		if expired > 0 {
+			o.Rdb.ZRemRangeByRank(ctx, o.Key, 0, int64(expired-1))
+		}
+		if expired < len(members) {
+			return
+		}
+	}
+}
+
+// trimBytes keeps the newest messages whose summed size fits in maxBytes.
+func (o *OffLine) trimBytes(ctx context.Context, maxBytes int64) {
+	total := int64(0)
+	for start := int64(0); ; start += retentionBatch {
+		members, err := o.Rdb.ZRevRange(ctx, o.Key, start, start+retentionBatch-1).Result()
+		if err != nil || len(members) == 0 {
+			return
+		}
+		for i, member := range members {
+			total += int64(len(member))
+			if total > maxBytes {
+				// ranks from the newest: start+i and older go
+				o.Rdb.ZRemRangeByRank(ctx, o.Key, 0, -(start+int64(i))-1)
+				return
+			}
+		}
+	}
+}
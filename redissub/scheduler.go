@@ -0,0 +1,176 @@
+package redissub
+
+import (
+	"context"
+	red "github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"log"
+	"time"
+)
+
+const (
+	scheduledZsetKey     = "redissub:scheduled:zset"
+	scheduledHashKey     = "redissub:scheduled:hash"
+	scheduledInflightKey = "redissub:scheduled:inflight"
+	scheduledLeaderKey   = "redissub:scheduled:leader"
+
+	defaultScheduleInterval  = time.Second
+	defaultScheduleBatchSize = 100
+
+	// scheduleInflightLease is how long a claimed job may take to publish before it is claimed again
+	scheduleInflightLease = time.Minute
+)
+
+// claimScript moves due jobs to the in-flight zset and returns them, the job stays in the hash until
+// it is published. In-flight jobs older than the lease, left by a crashed leader, are due again first.
+// KEYS[1] due zset, KEYS[2] job hash, KEYS[3] in-flight zset
+// ARGV[1] now in milliseconds, ARGV[2] max jobs, ARGV[3] lease deadline in milliseconds
+var claimScript = red.NewScript(`
+local stale = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[3])
+for _, id in ipairs(stale) do
+	redis.call('ZREM', KEYS[3], id)
+	redis.call('ZADD', KEYS[1], ARGV[1], id)
+end
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local jobs = {}
+for _, id in ipairs(ids) do
+	local job = redis.call('HGET', KEYS[2], id)
+	redis.call('ZREM', KEYS[1], id)
+	if job then
+		redis.call('ZADD', KEYS[3], ARGV[1], id)
+		table.insert(jobs, job)
+	end
+end
+return jobs
+`)
+
+type (
+	ScheduleOption struct {
+		Interval  time.Duration // poll interval, default 1s
+		BatchSize int           // max jobs fired per poll, default 100
+	}
+
+	scheduledJob struct {
+		Channel string `json:"Channel"`
+		Event   *Event `json:"Event"`
+	}
+)
+
+// PublishAt schedules event to be published on channel at the given time.
+// The returned id cancels it with CancelScheduled. A zero Event.Time is stamped when the job fires.
+func (p *PubSubClient) PublishAt(ctx context.Context, channel string, event *Event, at time.Time) (MessageID, error) {
+	if event == nil {
+		return "", ErrNilEvent
+	}
+	if event.Id == "" {
+		event.Id = genMessageId()
+	}
+	job, err := jsoniter.Marshal(&scheduledJob{Channel: channel, Event: event})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = p.Publisher.TxPipelined(ctx, func(pipe red.Pipeliner) error {
+		pipe.HSet(ctx, scheduledHashKey, event.Id, string(job))
+		pipe.ZAdd(ctx, scheduledZsetKey, &red.Z{
+			Score:  float64(at.UnixMilli()),
+			Member: event.Id,
+		})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return MessageID(event.Id), nil
+}
+
+func (p *PubSubClient) PublishAfter(ctx context.Context, channel string, event *Event, delay time.Duration) (MessageID, error) {
+	return p.PublishAt(ctx, channel, event, time.Now().Add(delay))
+}
+
+// CancelScheduled removes a scheduled message, it reports false when the message already fired or does not exist.
+func (p *PubSubClient) CancelScheduled(ctx context.Context, id MessageID) (bool, error) {
+	var removed *red.IntCmd
+	_, err := p.Publisher.TxPipelined(ctx, func(pipe red.Pipeliner) error {
+		removed = pipe.ZRem(ctx, scheduledZsetKey, string(id))
+		pipe.HDel(ctx, scheduledHashKey, string(id))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
+
+// runScheduler polls due jobs on the elected node and publishes them through Publish.
+func (p *PubSubClient) runScheduler(option *ScheduleOption) {
+	interval := option.Interval
+	if interval <= 0 {
+		interval = defaultScheduleInterval
+	}
+	batchSize := option.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultScheduleBatchSize
+	}
+	leader := &Leader{
+		Key: scheduledLeaderKey,
+		Id:  p.NodeId,
+		Rdb: p.Publisher,
+		Ttl: 3 * interval,
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx := context.Background()
+			if !leader.Acquire(ctx) {
+				continue
+			}
+			for {
+				if p.fireDueJobs(ctx, batchSize) < batchSize {
+					break
+				}
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// fireDueJobs publishes claimed jobs and drops them once published. A job claimed again after a crash
+// is published with the same Event.Id, so Publish dedupes it.
+func (p *PubSubClient) fireDueJobs(ctx context.Context, batchSize int) int {
+	now := time.Now()
+	jobs, err := claimScript.Run(ctx, p.Publisher, []string{scheduledZsetKey, scheduledHashKey, scheduledInflightKey},
+		now.UnixMilli(), batchSize, now.Add(-scheduleInflightLease).UnixMilli()).StringSlice()
+	if err != nil {
+		return 0
+	}
+
+	for _, item := range jobs {
+		var job scheduledJob
+		if err := jsoniter.Unmarshal([]byte(item), &job); err != nil || job.Event == nil {
+			continue
+		}
+		id := job.Event.Id
+		if _, err := p.Publish(ctx, job.Channel, job.Event); err != nil {
+			log.Printf("scheduled publish %v error: %v", id, err)
+			// put it back for the next poll
+			p.Publisher.TxPipelined(ctx, func(pipe red.Pipeliner) error {
+				pipe.ZRem(ctx, scheduledInflightKey, id)
+				pipe.ZAdd(ctx, scheduledZsetKey, &red.Z{Score: float64(time.Now().UnixMilli()), Member: id})
+				return nil
+			})
+			continue
+		}
+		p.Publisher.TxPipelined(ctx, func(pipe red.Pipeliner) error {
+			pipe.ZRem(ctx, scheduledInflightKey, id)
+			pipe.HDel(ctx, scheduledHashKey, id)
+			return nil
+		})
+	}
+	return len(jobs)
+}
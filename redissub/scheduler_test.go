@@ -0,0 +1,193 @@
+package redissub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func assertScheduledEmpty(t *testing.T, p *PubSubClient) {
+	t.Helper()
+	ctx := context.Background()
+	for _, key := range []string{scheduledZsetKey, scheduledHashKey, scheduledInflightKey} {
+		if n := p.Publisher.Exists(ctx, key).Val(); n != 0 {
+			t.Fatalf("%s left behind", key)
+		}
+	}
+}
+
+func TestScheduledJobFiresOnce(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	id, err := p.PublishAt(ctx, "room", &Event{Data: "due"}, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.PublishAfter(ctx, "room", &Event{Data: "later"}, time.Hour)
+
+	if n := p.fireDueJobs(ctx, 10); n != 1 {
+		t.Fatalf("fired %d jobs, want 1", n)
+	}
+	if n := p.fireDueJobs(ctx, 10); n != 0 {
+		t.Fatalf("fired %d jobs again", n)
+	}
+	stored := rdb.ZRange(ctx, GenOfflineKey("room"), 0, -1).Val()
+	if len(stored) != 1 {
+		t.Fatalf("offline log = %v", stored)
+	}
+	if rdb.ZScore(ctx, scheduledZsetKey, string(id)).Err() == nil || rdb.HExists(ctx, scheduledHashKey, string(id)).Val() {
+		t.Fatal("fired job still scheduled")
+	}
+	if n := rdb.ZCard(ctx, scheduledInflightKey).Val(); n != 0 {
+		t.Fatalf("%d jobs left in flight", n)
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	id, _ := p.PublishAt(ctx, "room", &Event{}, time.Now().Add(-time.Second))
+	if ok, err := p.CancelScheduled(ctx, id); !ok || err != nil {
+		t.Fatalf("cancel = %v, %v", ok, err)
+	}
+	if ok, _ := p.CancelScheduled(ctx, id); ok {
+		t.Fatal("second cancel reported a removal")
+	}
+	if n := p.fireDueJobs(ctx, 10); n != 0 {
+		t.Fatalf("fired %d cancelled jobs", n)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("room")).Val(); n != 0 {
+		t.Fatalf("cancelled job published %d messages", n)
+	}
+	assertScheduledEmpty(t, p)
+
+	fired, _ := p.PublishAt(ctx, "room", &Event{}, time.Now())
+	p.fireDueJobs(ctx, 10)
+	if ok, _ := p.CancelScheduled(ctx, fired); ok {
+		t.Fatal("cancel of a fired job reported a removal")
+	}
+}
+
+// claimAt claims due jobs as a leader at time at would, without publishing them.
+func claimAt(t *testing.T, p *PubSubClient, at time.Time) []string {
+	t.Helper()
+	jobs, err := claimScript.Run(context.Background(), p.Publisher, []string{scheduledZsetKey, scheduledHashKey, scheduledInflightKey},
+		at.UnixMilli(), 10, at.Add(-scheduleInflightLease).UnixMilli()).StringSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+func TestScheduledStaleInflightReclaimed(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	// a job claimed within the lease is left to its leader
+	p.PublishAt(ctx, "other", &Event{}, time.Now().Add(-time.Second))
+	claimAt(t, p, time.Now())
+
+	claimedAt := time.Now().Add(-2 * scheduleInflightLease)
+	p.PublishAt(ctx, "room", &Event{}, claimedAt)
+	// a leader claims the job and crashes before publishing it
+	if jobs := claimAt(t, p, claimedAt); len(jobs) != 1 {
+		t.Fatalf("claimed %v", jobs)
+	}
+	if n := rdb.ZCard(ctx, scheduledZsetKey).Val(); n != 0 {
+		t.Fatalf("claimed job still due")
+	}
+
+	if n := p.fireDueJobs(ctx, 10); n != 1 {
+		t.Fatalf("fired %d jobs, want the stale one", n)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("room")).Val(); n != 1 {
+		t.Fatalf("reclaimed job published %d messages", n)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("other")).Val(); n != 0 {
+		t.Fatal("job within its lease was claimed again")
+	}
+}
+
+func TestScheduledReclaimDedupes(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	claimedAt := time.Now().Add(-2 * scheduleInflightLease)
+	event := &Event{Data: "once"}
+	p.PublishAt(ctx, "room", event, claimedAt)
+	claimAt(t, p, claimedAt)
+	// the crashed leader published before it could drop the job
+	if _, err := p.Publish(ctx, "room", &Event{Id: event.Id, Data: "once"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := p.fireDueJobs(ctx, 10); n != 1 {
+		t.Fatalf("fired %d jobs, want the reclaimed one", n)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("room")).Val(); n != 1 {
+		t.Fatalf("offline log has %d messages, the reclaimed publish was not deduped", n)
+	}
+	assertScheduledEmpty(t, p)
+}
+
+func TestScheduledRetriedAfterFailedPublish(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	id, _ := p.PublishAt(ctx, "room", &Event{}, time.Now().Add(-time.Second))
+	// a key of the wrong type fails the publish script
+	rdb.Set(ctx, GenOfflineKey("room"), "broken", 0)
+	p.fireDueJobs(ctx, 10)
+	if rdb.ZScore(ctx, scheduledZsetKey, string(id)).Err() != nil {
+		t.Fatal("failed job not due again")
+	}
+	if n := rdb.ZCard(ctx, scheduledInflightKey).Val(); n != 0 {
+		t.Fatal("failed job left in flight")
+	}
+
+	rdb.Del(ctx, GenOfflineKey("room"))
+	if n := p.fireDueJobs(ctx, 10); n != 1 {
+		t.Fatalf("fired %d jobs on retry", n)
+	}
+	if n := rdb.ZCard(ctx, GenOfflineKey("room")).Val(); n != 1 {
+		t.Fatalf("retried job published %d messages", n)
+	}
+	assertScheduledEmpty(t, p)
+}
+
+func TestLeaderLease(t *testing.T) {
+	m, rdb := newTestRedis(t)
+	ctx := context.Background()
+
+	a := &Leader{Key: scheduledLeaderKey, Id: "a", Rdb: rdb, Ttl: time.Second}
+	b := &Leader{Key: scheduledLeaderKey, Id: "b", Rdb: rdb, Ttl: time.Second}
+	if !a.Acquire(ctx) {
+		t.Fatal("a did not take a free lock")
+	}
+	if b.Acquire(ctx) {
+		t.Fatal("b took a held lock")
+	}
+	m.FastForward(500 * time.Millisecond)
+	if !a.Acquire(ctx) {
+		t.Fatal("a could not renew")
+	}
+	m.FastForward(700 * time.Millisecond)
+	if b.Acquire(ctx) {
+		t.Fatal("b took a renewed lock")
+	}
+	// a stops renewing
+	m.FastForward(time.Second)
+	if !b.Acquire(ctx) {
+		t.Fatal("b did not take an expired lock")
+	}
+	if a.Acquire(ctx) {
+		t.Fatal("a took the lock back")
+	}
+}
@@ -0,0 +1,130 @@
+package redissub
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"sync/atomic"
+)
+
+var (
+	ErrShuttingDown = errors.New("pubsub client shutting down")
+	ErrClientClosed = errors.New("client closed")
+)
+
+// Shutdown stops accepting connections, flushes pending client buffers into waiters, closes every client
+// with a "server restarting" close frame and waits for all goroutines to exit or ctx to be done.
+func (p *PubSubClient) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&p.closing, 0, 1) {
+		return ErrShuttingDown
+	}
+	close(p.done)
+
+	// deliver what is already dispatched before flushing client buffers
+	stopped := make(chan struct{})
+	GoSafe(func() {
+		p.Dispatcher.Stop()
+		close(stopped)
+	})
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+
+	for _, client := range p.connectedClients() {
+		client.shutdown(websocket.CloseServiceRestart, "server restarting")
+	}
+
+	p.mu.Lock()
+	p.PubSub.Close()
+	p.mu.Unlock()
+
+	waited := make(chan struct{})
+	GoSafe(func() {
+		p.wg.Wait()
+		close(waited)
+	})
+	select {
+	case <-waited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *PubSubClient) isClosing() bool {
+	return atomic.LoadInt32(&p.closing) == 1
+}
+
+// goSafe runs fn in a goroutine Shutdown waits for.
+func (p *PubSubClient) goSafe(fn func()) {
+	p.wg.Add(1)
+	GoSafe(func() {
+		defer p.wg.Done()
+		fn()
+	})
+}
+
+// addClient registers client unless Shutdown started, checked under the lock Shutdown takes its snapshot with,
+// so every registered client is closed by Shutdown.
+func (p *PubSubClient) addClient(client *Client) bool {
+	p.clientsMu.Lock()
+	defer p.clientsMu.Unlock()
+	if p.isClosing() {
+		return false
+	}
+	p.clients[client] = struct{}{}
+	return true
+}
+
+func (p *PubSubClient) removeClient(client *Client) {
+	p.clientsMu.Lock()
+	defer p.clientsMu.Unlock()
+	delete(p.clients, client)
+}
+
+func (p *PubSubClient) clientsById(id string) []*Client {
+	clients := []*Client{}
+	for _, client := range p.connectedClients() {
+		if client.Id == id {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+func (p *PubSubClient) connectedClients() []*Client {
+	p.clientsMu.Lock()
+	defer p.clientsMu.Unlock()
+	clients := make([]*Client, 0, len(p.clients))
+	for client := range p.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// shutdown moves pending outbound frames back to the waiters and closes the connection with code.
+func (c *Client) shutdown(code int, reason string) {
+	frames := [][]byte{}
+	for {
+		message, ok := c.drainMessage()
+		if !ok {
+			break
+		}
+		frames = append(frames, message)
+	}
+	c.Solid.Flush(context.Background(), frames)
+
+	c.Close(code, reason)
+}
+
+func (c *Client) drainMessage() ([]byte, bool) {
+	for _, lane := range c.lanes {
+		select {
+		case message := <-lane:
+			return message, true
+		default:
+		}
+	}
+	return nil, false
+}
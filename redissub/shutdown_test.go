@@ -0,0 +1,90 @@
+package redissub
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func waitClients(t *testing.T, p *PubSubClient, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(p.connectedClients()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients connected, want %d", len(p.connectedClients()), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClientShutdownFlushesBuffers(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	c, peer := newTestConn(t, "u1", &SolidOption{ExpireTime: time.Hour, Rdb: rdb})
+
+	for _, event := range []*Event{
+		{Id: "a", Channel: "room"},
+		{Id: "b", Channel: "room", Priority: PriorityBulk},
+		{Id: "c"}, // no channel, no waiter to go back to
+	} {
+		frame, _ := jsoniter.Marshal(event)
+		c.Deliver(frame)
+	}
+	c.shutdown(websocket.CloseServiceRestart, "server restarting")
+
+	if got := waiterIds(t, rdb, "room", "u1"); len(got) != 2 || !got["a"] || !got["b"] {
+		t.Fatalf("waiter = %v, want the buffered a and b", got)
+	}
+	if n := c.queued(); n != 0 {
+		t.Fatalf("%d frames left in the buffers", n)
+	}
+	if code := readClose(t, peer); code != websocket.CloseServiceRestart {
+		t.Fatalf("close code = %d, want %d", code, websocket.CloseServiceRestart)
+	}
+}
+
+func TestShutdownClosesClients(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	server := newTestServer(t, p)
+
+	first := dialTest(t, server, "id=u1")
+	second := dialTest(t, server, "id=u2")
+	waitClients(t, p, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, conn := range []*websocket.Conn{first, second} {
+		if code := readClose(t, conn); code != websocket.CloseServiceRestart {
+			t.Fatalf("close code = %d, want %d", code, websocket.CloseServiceRestart)
+		}
+	}
+	if err := p.Shutdown(ctx); err != ErrShuttingDown {
+		t.Fatalf("second Shutdown = %v, want ErrShuttingDown", err)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?id=u3", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial after Shutdown = %v, %v, want 503", resp, err)
+	}
+}
+
+func TestAddClientAfterShutdown(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	p.Shutdown(context.Background())
+
+	if p.addClient(newTestClient()) {
+		t.Fatal("client registered after Shutdown")
+	}
+	if n := len(p.connectedClients()); n != 0 {
+		t.Fatalf("%d clients registered", n)
+	}
+}
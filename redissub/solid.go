@@ -4,118 +4,90 @@ import (
 	"context"
 	red "github.com/go-redis/redis/v8"
 	jsoniter "github.com/json-iterator/go"
+	"math/rand"
 	"time"
 )
 
+const defaultBackoffMax = 5 * time.Minute
+
 type (
+	// GiveUp is called when a message reached MaxAttempts and is no longer resent.
+	GiveUp func(client *Client, channel string, event *Event, attempts int)
+
 	SolidOption struct {
 		ExpireTime time.Duration // Key ttl
 		Duration   time.Duration // resend message interval
 		Rdb        *red.Client
+		// MaxAttempts bounds the deliveries of a message, the first one included, 0 resends until the key expires
+		MaxAttempts int
+		// BackoffBase is the wait before the first resend, doubled after each one, default Duration
+		BackoffBase time.Duration
+		// BackoffMax caps the wait between resends, default 5 minutes
+		BackoffMax time.Duration
+		OnGiveUp   GiveUp
+		// DeadLetterTTL expires a dead letter stream after its last entry, default 7 days
+		DeadLetterTTL time.Duration
 	}
 
 	Solid struct {
-		Client     *Client
-		ExpireTime time.Duration // Key ttl
-		Duration   time.Duration // resend message interval
-		Rdb        *red.Client
+		Client        *Client
+		ExpireTime    time.Duration // Key ttl
+		Duration      time.Duration // resend message interval
+		Rdb           *red.Client
+		MaxAttempts   int
+		BackoffBase   time.Duration
+		BackoffMax    time.Duration
+		OnGiveUp      GiveUp
+		DeadLetterTTL time.Duration
 	}
 )
 
 func MustNewSolid(solidOption *SolidOption, client *Client) *Solid {
 	return &Solid{
-		Client:     client,
-		ExpireTime: solidOption.ExpireTime,
-		Duration:   solidOption.Duration,
-		Rdb:        solidOption.Rdb,
+		Client:        client,
+		ExpireTime:    solidOption.ExpireTime,
+		Duration:      solidOption.Duration,
+		Rdb:           solidOption.Rdb,
+		MaxAttempts:   solidOption.MaxAttempts,
+		BackoffBase:   solidOption.BackoffBase,
+		BackoffMax:    solidOption.BackoffMax,
+		OnGiveUp:      solidOption.OnGiveUp,
+		DeadLetterTTL: solidOption.DeadLetterTTL,
 	}
 }
 
 func (s *Solid) PullOfflineMessage() {
 	ctx := context.Background()
-	for _, channel := range s.Client.Channels {
-		rdb := s.Rdb
-		expireTime := s.ExpireTime
-		id := s.Client.Id
-
-		online := &Online{
-			Waiter: &Waiter{
-				Key:        GenWaiterKey(channel, id),
-				Rdb:        rdb,
-				ExpireTime: expireTime,
-			},
-			Receiver: &Receiver{
-				Key:        GenReceiverKey(channel, id),
-				Rdb:        rdb,
-				ExpireTime: expireTime,
-			},
-			Offset: &Offset{
-				Key:        GenOffsetKey(channel, id),
-				Rdb:        rdb,
-				ExpireTime: expireTime,
-			},
-		}
-
+	for _, channel := range s.Client.channels() {
 		offline := &OffLine{
-			ExpireTime: expireTime,
-			Rdb:        rdb,
+			ExpireTime: s.ExpireTime,
+			Rdb:        s.Rdb,
 			Key:        GenOfflineKey(channel),
 		}
-		offline.PullOffLine(ctx, online)
+		offline.PullOffLine(ctx, s.online(channel), s.Client.filter(channel))
 	}
 }
 
 func (s *Solid) Push(ctx context.Context, channel string, event []byte) {
-	rdb := s.Rdb
-	expireTime := s.ExpireTime
-	id := s.Client.Id
-	online := &Online{
-		Waiter: &Waiter{
-			Key:        GenWaiterKey(channel, id),
-			Rdb:        rdb,
-			ExpireTime: expireTime,
-		},
-		Receiver: &Receiver{
-			Key:        GenReceiverKey(channel, id),
-			Rdb:        rdb,
-			ExpireTime: expireTime,
-		},
-		Offset: &Offset{
-			Key:        GenOffsetKey(channel, id),
-			Rdb:        rdb,
-			ExpireTime: expireTime,
-		},
-	}
-	online.Waiter.Push(ctx, event)
+	s.online(channel).Waiter.Push(ctx, event)
 }
 
-func (s *Solid) Ack(ctx context.Context, event *Event) {
-	for _, channel := range s.Client.Channels {
-		rdb := s.Rdb
-		expireTime := s.ExpireTime
-		id := s.Client.Id
-
-		online := &Online{
-			Waiter: &Waiter{
-				Key:        GenWaiterKey(channel, id),
-				Rdb:        rdb,
-				ExpireTime: expireTime,
-			},
-			Receiver: &Receiver{
-				Key:        GenReceiverKey(channel, id),
-				Rdb:        rdb,
-				ExpireTime: expireTime,
-			},
-			Offset: &Offset{
-				Key:        GenOffsetKey(channel, id),
-				Rdb:        rdb,
-				ExpireTime: expireTime,
-			},
+// Flush puts frames taken from the client buffers back into their channel waiter, frames without channel are dropped.
+func (s *Solid) Flush(ctx context.Context, frames [][]byte) {
+	for _, frame := range frames {
+		var event Event
+		if err := jsoniter.Unmarshal(frame, &event); err != nil || event.Channel == "" || event.Id == "" {
+			continue
 		}
-		online.Ack(ctx, event)
+		s.Push(ctx, event.Channel, frame)
 	}
 }
 
+// Ack acknowledges event on its channel, events without Channel are acked on every subscribed channel.
+func (s *Solid) Ack(ctx context.Context, event *Event) {
+	s.AckBatch(ctx, &AckRequest{Id: event.Id, Channel: event.Channel, Seq: event.Seq})
+}
+
 func (s *Solid) MonitorReSend() {
 	if s.Duration == 0 {
 		return
@@ -129,42 +101,133 @@ func (s *Solid) MonitorReSend() {
 	for {
 		select {
 		case <-ticker.C:
-			for _, channel := range s.Client.Channels {
+			for _, channel := range s.Client.channels() {
 				c := channel
 				GoSafe(func() {
-					rdb := s.Rdb
-					expireTime := s.ExpireTime
-					id := s.Client.Id
-
-					online := &Online{
-						Waiter: &Waiter{
-							Key:        GenWaiterKey(c, id),
-							Rdb:        rdb,
-							ExpireTime: expireTime,
-						},
-						Receiver: &Receiver{
-							Key:        GenReceiverKey(c, id),
-							Rdb:        rdb,
-							ExpireTime: expireTime,
-						},
-						Offset: &Offset{
-							Key:        GenOffsetKey(c, id),
-							Rdb:        rdb,
-							ExpireTime: expireTime,
-						},
-					}
-					ctx := context.Background()
-					strings := online.Waiter.All(ctx)
-					for _, item := range strings {
-						str := item.(string)
-						if s.IsFresh(str) {
-							continue
-						}
-						s.Client.Send <- []byte(str)
-					}
+					s.reSend(c)
 				})
 			}
+		case <-s.Client.Done():
+			return
+		}
+	}
+}
+
+// reSend delivers due waiter messages on the bulk lane with exponential backoff between attempts,
+// expired ones and ones out of attempts are moved to the dead letter stream.
+func (s *Solid) reSend(channel string) {
+	ctx := context.Background()
+	online := s.online(channel)
+	now := time.Now()
+	strings := online.Waiter.All(ctx)
+	events := make([]*Event, 0, len(strings))
+	frames := make([][]byte, 0, len(strings))
+	ids := make([]string, 0, len(strings))
+	for _, item := range strings {
+		var event Event
+		frame := []byte(item.(string))
+		if err := jsoniter.Unmarshal(frame, &event); err != nil {
+			continue
+		}
+		events = append(events, &event)
+		frames = append(frames, frame)
+		ids = append(ids, event.Id)
+	}
+	attempts := online.Attempts.Get(ctx, ids)
+
+	for i, event := range events {
+		attempt, ok := attempts[event.Id]
+		if !ok {
+			// only the live delivery so far
+			attempt = &Attempt{Count: 1, NextAt: event.Time + s.backoff(1).Milliseconds()}
+		}
+		if event.Expired(now) {
+			online.deadLetter(ctx, event, frames[i], DeadLetterExpired, attempt.Count) // kept in waiter on error, next tick again
+			continue
+		}
+		if now.UnixMilli() < attempt.NextAt {
+			continue
 		}
+		if s.outOfAttempts(attempt) {
+			s.giveUp(ctx, online, channel, event, frames[i], attempt.Count) // kept in waiter on error, next tick again
+			continue
+		}
+		if err := s.Client.DeliverPriority(PriorityBulk, frames[i]); err != nil {
+			return // still in waiter, try next tick
+		}
+		attempt.Count++
+		attempt.NextAt = now.Add(s.backoff(attempt.Count)).UnixMilli()
+		online.Attempts.Set(ctx, event.Id, attempt)
+	}
+}
+
+func (s *Solid) outOfAttempts(attempt *Attempt) bool {
+	return s.MaxAttempts > 0 && attempt.Count >= s.MaxAttempts
+}
+
+// giveUp dead letters a message out of attempts and calls OnGiveUp once it is stored.
+func (s *Solid) giveUp(ctx context.Context, online *Online, channel string, event *Event, frame []byte, attempts int) error {
+	if err := online.deadLetter(ctx, event, frame, DeadLetterMaxAttempts, attempts); err != nil {
+		return err
+	}
+	if s.OnGiveUp != nil {
+		s.OnGiveUp(s.Client, channel, event, attempts)
+	}
+	return nil
+}
+
+// backoff is the wait after delivery number attempts, BackoffBase doubled per resend up to BackoffMax,
+// plus up to a quarter of it as jitter so clients reconnecting together do not resend in lockstep.
+func (s *Solid) backoff(attempts int) time.Duration {
+	base := s.BackoffBase
+	if base <= 0 {
+		base = s.Duration
+	}
+	max := s.BackoffMax
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+	wait := base
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait/4)+1))
+}
+
+func (s *Solid) online(channel string) *Online {
+	return newOnline(s.Rdb, s.ExpireTime, s.DeadLetterTTL, channel, s.Client.Id)
+}
+
+func newOnline(rdb *red.Client, expireTime, deadLetterTTL time.Duration, channel, id string) *Online {
+	return &Online{
+		Waiter: &Waiter{
+			Key:        GenWaiterKey(channel, id),
+			Rdb:        rdb,
+			ExpireTime: expireTime,
+		},
+		Receiver: &Receiver{
+			Key:        GenReceiverKey(channel, id),
+			Rdb:        rdb,
+			ExpireTime: expireTime,
+		},
+		Offset: &Offset{
+			Key:        GenOffsetKey(channel, id),
+			Rdb:        rdb,
+			ExpireTime: expireTime,
+		},
+		Attempts: &Attempts{
+			Key:        GenAttemptKey(channel, id),
+			Rdb:        rdb,
+			ExpireTime: expireTime,
+		},
+		DeadLetters: &DeadLetters{
+			Key:        GenDeadLetterKey(channel, id),
+			Rdb:        rdb,
+			ExpireTime: deadLetterTTL,
+		},
 	}
 }
 
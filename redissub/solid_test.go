@@ -0,0 +1,102 @@
+package redissub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestBackoff(t *testing.T) {
+	s := &Solid{BackoffBase: time.Second, BackoffMax: 10 * time.Second}
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			// jitter adds up to a quarter of the wait
+			if got := s.backoff(tc.attempts); got < tc.want || got > tc.want+tc.want/4 {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v]", tc.attempts, got, tc.want, tc.want+tc.want/4)
+			}
+		}
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	s := &Solid{Duration: 3 * time.Second}
+	if got := s.backoff(1); got < 3*time.Second || got > 3*time.Second+3*time.Second/4 {
+		t.Fatalf("backoff(1) = %v, want based on Duration", got)
+	}
+	if got := s.backoff(100); got < defaultBackoffMax || got > defaultBackoffMax+defaultBackoffMax/4 {
+		t.Fatalf("backoff(100) = %v, want capped at %v", got, defaultBackoffMax)
+	}
+}
+
+func TestOutOfAttempts(t *testing.T) {
+	for _, tc := range []struct {
+		max, count int
+		want       bool
+	}{
+		{0, 1000, false},
+		{3, 2, false},
+		{3, 3, true},
+		{3, 4, true},
+	} {
+		s := &Solid{MaxAttempts: tc.max}
+		if got := s.outOfAttempts(&Attempt{Count: tc.count}); got != tc.want {
+			t.Errorf("MaxAttempts %d Count %d = %v, want %v", tc.max, tc.count, got, tc.want)
+		}
+	}
+}
+
+func TestReSendGivesUp(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	p := newTestPubSub(t, rdb)
+	ctx := context.Background()
+
+	c := newTestSubscriber(p, "u1", "orders")
+	c.Solid.MaxAttempts = 2
+	c.Solid.BackoffBase = time.Millisecond
+	c.Solid.BackoffMax = time.Millisecond
+	gaveUp := 0
+	c.Solid.OnGiveUp = func(client *Client, channel string, event *Event, attempts int) {
+		gaveUp = attempts
+	}
+	online := c.Solid.online("orders")
+	frame, _ := jsoniter.Marshal(&Event{Id: "m1", Time: time.Now().UnixMilli()})
+	online.Waiter.Push(ctx, frame)
+
+	// the live delivery counts as the first attempt, one resend is left
+	time.Sleep(5 * time.Millisecond)
+	c.Solid.reSend("orders")
+	if frames := drain(c); len(frames) != 1 {
+		t.Fatalf("resent frames = %v", frames)
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.Solid.reSend("orders")
+	if frames := drain(c); len(frames) != 0 {
+		t.Fatalf("resent out of attempts: %v", frames)
+	}
+
+	if gaveUp != 2 {
+		t.Fatalf("OnGiveUp attempts = %d, want 2", gaveUp)
+	}
+	if got := waiterIds(t, rdb, "orders", "u1"); len(got) != 0 {
+		t.Fatalf("waiter = %v after give up", got)
+	}
+	letters, err := online.DeadLetters.List(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Reason != DeadLetterMaxAttempts || letters[0].Attempts != 2 || letters[0].Event.Id != "m1" {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+}
@@ -0,0 +1,119 @@
+package redissub
+
+import (
+	"context"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"strings"
+	"time"
+)
+
+const (
+	sweeperLeaderKey = "redissub:sweeper:leader"
+
+	defaultSweepInterval = time.Minute
+	sweepScanCount       = 500
+)
+
+type (
+	SweepOption struct {
+		Interval time.Duration // sweep interval, default 1m
+	}
+)
+
+// runSweeper removes expired messages from offline logs and moves the ones in waiter hashes to the dead letter
+// streams, on the elected node.
+func (p *PubSubClient) runSweeper(option *SweepOption) {
+	interval := option.Interval
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+	leader := &Leader{
+		Key: sweeperLeaderKey,
+		Id:  p.NodeId,
+		Rdb: p.Publisher,
+		Ttl: 3 * interval,
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx := context.Background()
+			if !leader.Acquire(ctx) {
+				continue
+			}
+			p.sweepOffline(ctx)
+			p.sweepWaiters(ctx)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *PubSubClient) sweepOffline(ctx context.Context) {
+	rdb := p.Publisher
+	now := time.Now()
+	iter := rdb.Scan(ctx, 0, fmt.Sprintf(offlinePrefix, "*"), sweepScanCount).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		expired := []interface{}{}
+		members := rdb.ZScan(ctx, key, 0, "", sweepScanCount).Iterator()
+		for members.Next(ctx) {
+			member := members.Val()
+			if isExpired([]byte(member), now) {
+				expired = append(expired, member)
+			}
+			members.Next(ctx) // skip score
+		}
+		if len(expired) > 0 {
+			rdb.ZRem(ctx, key, expired...)
+		}
+	}
+}
+
+func (p *PubSubClient) sweepWaiters(ctx context.Context) {
+	rdb := p.Publisher
+	now := time.Now()
+	iter := rdb.Scan(ctx, 0, fmt.Sprintf(waiterPrefix, "*", "*"), sweepScanCount).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		expired := []string{}
+		frames := [][]byte{}
+		fields := rdb.HScan(ctx, key, 0, "", sweepScanCount).Iterator()
+		for fields.Next(ctx) {
+			field := fields.Val()
+			if !fields.Next(ctx) {
+				break
+			}
+			var event Event
+			data := []byte(fields.Val())
+			if err := jsoniter.Unmarshal(data, &event); err == nil && event.Expired(now) {
+				expired = append(expired, field)
+				frames = append(frames, data)
+			}
+		}
+		if len(expired) > 0 {
+			attempts := &Attempts{Key: waiterKeyTo(key, attemptPrefix), Rdb: rdb}
+			deadLetters := &DeadLetters{Key: waiterKeyTo(key, deadLetterPrefix), Rdb: rdb}
+			counts := attempts.Get(ctx, expired)
+			for i, field := range expired {
+				count := 1
+				if attempt, ok := counts[field]; ok {
+					count = attempt.Count
+				}
+				deadLetters.Add(ctx, frames[i], DeadLetterExpired, count)
+			}
+			rdb.HDel(ctx, key, expired...)
+			rdb.HDel(ctx, attempts.Key, expired...)
+		}
+	}
+}
+
+// waiterKeyTo maps a waiter key to the key with prefix of the same channel and client.
+func waiterKeyTo(waiterKey, prefix string) string {
+	return strings.Replace(waiterKey, waiterPrefix[:strings.Index(waiterPrefix, "%")],
+		prefix[:strings.Index(prefix, "%")], 1)
+}